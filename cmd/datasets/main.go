@@ -2,6 +2,7 @@ package main
 
 import (
 	"hermes/pkg/common/crud"
+	"hermes/pkg/credentials"
 	"hermes/pkg/datasets"
 	"hermes/pkg/handlers"
 	"os"
@@ -12,14 +13,23 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 )
 
 var (
-	TableName  = os.Getenv("TABLE_NAME")
-	dynaClient dynamodbiface.DynamoDBAPI
-	ssmClient  *ssm.SSM
-	repo       crud.CrudRepository
+	TableName             = os.Getenv("TABLE_NAME")
+	NotificationTableName = os.Getenv("NOTIFICATION_TABLE_NAME")
+	HealthTableName       = os.Getenv("HEALTH_TABLE_NAME")
+	dynaClient            dynamodbiface.DynamoDBAPI
+	ssmClient             ssmiface.SSMAPI
+	s3Client              s3iface.S3API
+	repo                  crud.CrudRepository
+	notificationRepo      crud.CrudRepository
+	healthRepo            *datasets.HealthRepository
 )
 
 func getAwsSession() (*session.Session, error) {
@@ -28,8 +38,9 @@ func getAwsSession() (*session.Session, error) {
 
 	if isDev == "true" {
 		return session.NewSession(&aws.Config{
-			Region:   aws.String(region),
-			Endpoint: aws.String("http://host.docker.internal:4566"),
+			Region:           aws.String(region),
+			Endpoint:         aws.String("http://host.docker.internal:4566"),
+			S3ForcePathStyle: aws.Bool(true),
 		},
 		)
 	}
@@ -48,23 +59,47 @@ func main() {
 	}
 	dynaClient = dynamodb.New(awsSession)
 	ssmClient = ssm.New(awsSession)
+	s3Client = s3.New(awsSession)
+	credentials.Register("ssm", credentials.NewSSMProvider(ssmClient))
+	credentials.Register("secretsmanager", credentials.NewSecretsManagerProvider(secretsmanager.New(awsSession)))
+	credentials.Register("rds-iam", credentials.NewRDSIAMProvider(aws.StringValue(awsSession.Config.Region), awsSession.Config.Credentials))
+	datasets.RegisterDriver("csv", datasets.NewCSVDriver(s3Client))
+	datasets.RegisterDriver("dynamodb", datasets.NewDynamoDBDriver(dynaClient))
 	repo = crud.InitDynamoDbRepo(TableName, dynaClient)
+	notificationRepo = crud.InitDynamoDbRepo(NotificationTableName, dynaClient)
+	healthRepo = datasets.InitHealthRepo(HealthTableName, dynaClient)
 	lambda.Start(handler)
 }
 
 func handler(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
 	switch req.HTTPMethod {
 	case "GET":
+		if id, action := handlers.PathAction(req); action == "health" {
+			return datasets.GetDatasetHealth(req, repo, healthRepo, id)
+		}
 		return datasets.GetDataset(req, repo)
 	case "POST":
-		if req.PathParameters["id"] == "test-connection" {
-			return datasets.TestConnection(req, ssmClient)
+		id, action := handlers.PathAction(req)
+		if id == "test-connection" {
+			return datasets.TestConnection(req)
+		}
+		if action == "upload" {
+			return datasets.UploadDataset(req, repo, s3Client, id)
+		}
+		if action == "reveal-credentials" {
+			return datasets.RevealCredentials(req, repo, id)
+		}
+		if action == "rotate-credentials" {
+			return datasets.RotateCredentials(req, repo, id)
+		}
+		if action == "test" {
+			return datasets.TestDataset(req, repo, notificationRepo, id)
 		}
-		return datasets.NewDataset(req, repo, ssmClient)
+		return datasets.NewDataset(req, repo)
 	case "PUT":
-		return datasets.SaveDataset(req, repo, ssmClient)
+		return datasets.SaveDataset(req, repo)
 	case "DELETE":
-		return datasets.RemoveDataset(req, repo, ssmClient)
+		return datasets.RemoveDataset(req, repo, s3Client)
 	default:
 		return handlers.UnhandledMethod()
 	}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"hermes/pkg/audiences"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/credentials"
+	"hermes/pkg/datasets"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	awslambda "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+var (
+	TableName        = os.Getenv("TABLE_NAME")
+	DatasetTableName = os.Getenv("DATASET_TABLE_NAME")
+	dynaClient       dynamodbiface.DynamoDBAPI
+	s3Client         s3iface.S3API
+	lambdaClient     lambdaiface.LambdaAPI
+	repo             crud.CrudRepository
+	datasetRepo      crud.CrudRepository
+)
+
+func getAwsSession() (*session.Session, error) {
+	region := os.Getenv("AWS_REGION")
+	isDev := os.Getenv("IS_DEV")
+
+	if isDev == "true" {
+		return session.NewSession(&aws.Config{
+			Region:           aws.String(region),
+			Endpoint:         aws.String("http://host.docker.internal:4566"),
+			S3ForcePathStyle: aws.Bool(true),
+		},
+		)
+	}
+
+	return session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	},
+	)
+}
+
+func main() {
+	awsSession, err := getAwsSession()
+
+	if err != nil {
+		return
+	}
+	dynaClient = dynamodb.New(awsSession)
+	s3Client = s3.New(awsSession)
+	lambdaClient = awslambda.New(awsSession)
+	credentials.Register("ssm", credentials.NewSSMProvider(ssm.New(awsSession)))
+	credentials.Register("secretsmanager", credentials.NewSecretsManagerProvider(secretsmanager.New(awsSession)))
+	credentials.Register("rds-iam", credentials.NewRDSIAMProvider(aws.StringValue(awsSession.Config.Region), awsSession.Config.Credentials))
+	datasets.RegisterDriver("csv", datasets.NewCSVDriver(s3Client))
+	datasets.RegisterDriver("dynamodb", datasets.NewDynamoDBDriver(dynaClient))
+	repo = crud.InitDynamoDbRepo(TableName, dynaClient)
+	datasetRepo = crud.InitDynamoDbRepo(DatasetTableName, dynaClient)
+	// runs that are out of time hand the extraction over to a new
+	// invocation of this same function
+	audiences.ExtractorFunctionName = os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	lambda.Start(handler)
+}
+
+func handler(ctx context.Context, event audiences.ExtractionEvent) (*audiences.Manifest, error) {
+	return audiences.RunExtraction(ctx, event, repo, datasetRepo, s3Client, lambdaClient)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/common/redact"
+	"hermes/pkg/credentials"
+	"hermes/pkg/datasets"
+	"hermes/pkg/notifications"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+var (
+	TableName             = os.Getenv("TABLE_NAME")
+	NotificationTableName = os.Getenv("NOTIFICATION_TABLE_NAME")
+	HealthTableName       = os.Getenv("HEALTH_TABLE_NAME")
+	dynaClient            dynamodbiface.DynamoDBAPI
+	repo                  crud.CrudRepository
+	notificationRepo      crud.CrudRepository
+	healthRepo            *datasets.HealthRepository
+)
+
+func getAwsSession() (*session.Session, error) {
+	region := os.Getenv("AWS_REGION")
+	isDev := os.Getenv("IS_DEV")
+
+	if isDev == "true" {
+		return session.NewSession(&aws.Config{
+			Region:           aws.String(region),
+			Endpoint:         aws.String("http://host.docker.internal:4566"),
+			S3ForcePathStyle: aws.Bool(true),
+		},
+		)
+	}
+
+	return session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	},
+	)
+}
+
+func main() {
+	awsSession, err := getAwsSession()
+
+	if err != nil {
+		return
+	}
+	dynaClient = dynamodb.New(awsSession)
+	credentials.Register("ssm", credentials.NewSSMProvider(ssm.New(awsSession)))
+	credentials.Register("secretsmanager", credentials.NewSecretsManagerProvider(secretsmanager.New(awsSession)))
+	credentials.Register("rds-iam", credentials.NewRDSIAMProvider(aws.StringValue(awsSession.Config.Region), awsSession.Config.Credentials))
+	datasets.RegisterDriver("csv", datasets.NewCSVDriver(s3.New(awsSession)))
+	datasets.RegisterDriver("dynamodb", datasets.NewDynamoDBDriver(dynaClient))
+	repo = crud.InitDynamoDbRepo(TableName, dynaClient)
+	notificationRepo = crud.InitDynamoDbRepo(NotificationTableName, dynaClient)
+	healthRepo = datasets.InitHealthRepo(HealthTableName, dynaClient)
+	lambda.Start(handler)
+}
+
+func handler(event events.CloudWatchEvent) error {
+	checked, err := datasets.MonitorDatasets(repo, healthRepo)
+	if err != nil {
+		return err
+	}
+
+	threshold := datasets.HealthFailureThreshold()
+	risks := map[string]string{}
+	for _, d := range checked {
+		redact.Println("dataset", d.Id, d.Health.Status, d.Health.LastError)
+		if d.Health.ConsecutiveFailures >= threshold {
+			risks[d.Id] = fmt.Sprintf("dataset %s failed %d consecutive health checks: %s", d.Id, d.Health.ConsecutiveFailures, d.Health.LastError)
+		}
+	}
+
+	return notifications.SyncDatasetRisk(notificationRepo, risks)
+}
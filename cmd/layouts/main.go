@@ -0,0 +1,73 @@
+package main
+
+import (
+	"hermes/pkg/common/crud"
+	"hermes/pkg/handlers"
+	"hermes/pkg/layouts"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	TableName             = os.Getenv("TABLE_NAME")
+	NotificationTableName = os.Getenv("NOTIFICATION_TABLE_NAME")
+	dynaClient            dynamodbiface.DynamoDBAPI
+	repo                  crud.CrudRepository
+	notificationRepo      crud.CrudRepository
+)
+
+func getAwsSession() (*session.Session, error) {
+	region := os.Getenv("AWS_REGION")
+	isDev := os.Getenv("IS_DEV")
+
+	if isDev == "true" {
+		return session.NewSession(&aws.Config{
+			Region:   aws.String(region),
+			Endpoint: aws.String("http://host.docker.internal:4566"),
+		},
+		)
+	}
+
+	return session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	},
+	)
+}
+
+func main() {
+	awsSession, err := getAwsSession()
+
+	if err != nil {
+		return
+	}
+	dynaClient = dynamodb.New(awsSession)
+	repo = crud.InitDynamoDbRepo(TableName, dynaClient)
+	notificationRepo = crud.InitDynamoDbRepo(NotificationTableName, dynaClient)
+	lambda.Start(handler)
+}
+
+func handler(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	id, action := handlers.PathAction(req)
+
+	switch req.HTTPMethod {
+	case "GET":
+		if action == "usage" {
+			return layouts.GetLayoutUsage(req, repo, notificationRepo, id)
+		}
+		return layouts.GetLayout(req, repo)
+	case "POST":
+		return layouts.NewLayout(req, repo)
+	case "PUT":
+		return layouts.SaveLayout(req, repo, notificationRepo)
+	case "DELETE":
+		return layouts.RemoveLayout(req, repo, notificationRepo)
+	default:
+		return handlers.UnhandledMethod()
+	}
+}
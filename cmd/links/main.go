@@ -0,0 +1,67 @@
+package main
+
+import (
+	"hermes/pkg/common/crud"
+	"hermes/pkg/handlers"
+	"hermes/pkg/links"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	TableName      = os.Getenv("TABLE_NAME")
+	ClickTableName = os.Getenv("CLICK_TABLE_NAME")
+	dynaClient     dynamodbiface.DynamoDBAPI
+	tracker        *links.Tracker
+	clickRepo      *links.ClickRepository
+)
+
+func getAwsSession() (*session.Session, error) {
+	region := os.Getenv("AWS_REGION")
+	isDev := os.Getenv("IS_DEV")
+
+	if isDev == "true" {
+		return session.NewSession(&aws.Config{
+			Region:   aws.String(region),
+			Endpoint: aws.String("http://host.docker.internal:4566"),
+		},
+		)
+	}
+
+	return session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	},
+	)
+}
+
+func main() {
+	if err := links.CheckEnv(); err != nil {
+		log.Fatal(err)
+	}
+
+	awsSession, err := getAwsSession()
+
+	if err != nil {
+		return
+	}
+	dynaClient = dynamodb.New(awsSession)
+	tracker = links.NewTrackerFromEnv(crud.InitDynamoDbRepo(TableName, dynaClient))
+	clickRepo = links.InitClickRepo(ClickTableName, dynaClient)
+	lambda.Start(handler)
+}
+
+func handler(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	switch req.HTTPMethod {
+	case "GET":
+		return links.Redirect(req, tracker, clickRepo)
+	default:
+		return handlers.UnhandledMethod()
+	}
+}
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"hermes/pkg/audiences"
 	"hermes/pkg/common/crud"
+	"hermes/pkg/credentials"
+	"hermes/pkg/datasets"
+	"hermes/pkg/delivery"
 	"hermes/pkg/handlers"
+	"hermes/pkg/links"
 	"hermes/pkg/notifications"
+	"hermes/pkg/rendering"
+	"log"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -12,12 +20,34 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	awslambda "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/ssm"
 )
 
 var (
-	TableName  = os.Getenv("TABLE_NAME")
-	dynaClient dynamodbiface.DynamoDBAPI
-	repo       crud.CrudRepository
+	TableName         = os.Getenv("TABLE_NAME")
+	DatasetTableName  = os.Getenv("DATASET_TABLE_NAME")
+	SnapshotTableName = os.Getenv("SNAPSHOT_TABLE_NAME")
+	LayoutTableName   = os.Getenv("LAYOUT_TABLE_NAME")
+	LinkTableName     = os.Getenv("LINK_TABLE_NAME")
+	MessageTableName  = os.Getenv("MESSAGE_TABLE_NAME")
+	IdempotencyTable  = os.Getenv("IDEMPOTENCY_TABLE_NAME")
+	dynaClient        dynamodbiface.DynamoDBAPI
+	s3Client          s3iface.S3API
+	lambdaClient      lambdaiface.LambdaAPI
+	repo              crud.CrudRepository
+	datasetRepo       crud.CrudRepository
+	layoutRepo        crud.CrudRepository
+	linkRepo          crud.CrudRepository
+	snapshotRepo      *audiences.SnapshotRepository
+	messageRepo       *delivery.MessageRepository
+	idempotencyRepo   *delivery.IdempotencyRepository
 )
 
 func getAwsSession() (*session.Session, error) {
@@ -26,8 +56,9 @@ func getAwsSession() (*session.Session, error) {
 
 	if isDev == "true" {
 		return session.NewSession(&aws.Config{
-			Region:   aws.String(region),
-			Endpoint: aws.String("http://host.docker.internal:4566"),
+			Region:           aws.String(region),
+			Endpoint:         aws.String("http://host.docker.internal:4566"),
+			S3ForcePathStyle: aws.Bool(true),
 		},
 		)
 	}
@@ -39,25 +70,85 @@ func getAwsSession() (*session.Session, error) {
 }
 
 func main() {
+	if err := links.CheckEnv(); err != nil {
+		log.Fatal(err)
+	}
+
 	awsSession, err := getAwsSession()
 
 	if err != nil {
 		return
 	}
 	dynaClient = dynamodb.New(awsSession)
+	s3Client = s3.New(awsSession)
+	lambdaClient = awslambda.New(awsSession)
+	credentials.Register("ssm", credentials.NewSSMProvider(ssm.New(awsSession)))
+	credentials.Register("secretsmanager", credentials.NewSecretsManagerProvider(secretsmanager.New(awsSession)))
+	credentials.Register("rds-iam", credentials.NewRDSIAMProvider(aws.StringValue(awsSession.Config.Region), awsSession.Config.Credentials))
+	datasets.RegisterDriver("csv", datasets.NewCSVDriver(s3Client))
+	datasets.RegisterDriver("dynamodb", datasets.NewDynamoDBDriver(dynaClient))
+	snsClient := sns.New(awsSession)
+	delivery.Register(delivery.ChannelEmail, delivery.NewSESSender(ses.New(awsSession), os.Getenv("EMAIL_FROM")))
+	delivery.Register(delivery.ChannelSMS, delivery.NewSMSSender(snsClient, os.Getenv("SMS_SENDER_ID")))
+	delivery.Register(delivery.ChannelPush, delivery.NewPushSender(snsClient, os.Getenv("PUSH_PLATFORM_APPLICATION_ARN")))
 	repo = crud.InitDynamoDbRepo(TableName, dynaClient)
+	datasetRepo = crud.InitDynamoDbRepo(DatasetTableName, dynaClient)
+	layoutRepo = crud.InitDynamoDbRepo(LayoutTableName, dynaClient)
+	linkRepo = crud.InitDynamoDbRepo(LinkTableName, dynaClient)
+	snapshotRepo = audiences.InitSnapshotRepo(SnapshotTableName, dynaClient, s3Client)
+	messageRepo = delivery.InitMessageRepo(MessageTableName, dynaClient)
+	idempotencyRepo = delivery.InitIdempotencyRepo(IdempotencyTable, dynaClient)
 	lambda.Start(handler)
 }
 
 func handler(req events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	id, action := handlers.PathAction(req)
+
 	switch req.HTTPMethod {
 	case "GET":
+		if strings.HasPrefix(action, "extractions/") {
+			return audiences.GetExtraction(req, s3Client, id, strings.TrimPrefix(action, "extractions/"))
+		}
+		if action == "locales" {
+			return notifications.GetLocaleReport(req, repo, id)
+		}
+		if action == "snapshots" {
+			return audiences.ListSnapshots(req, snapshotRepo, id)
+		}
+		if strings.HasPrefix(action, "snapshots/") {
+			return audiences.InspectSnapshot(req, snapshotRepo, id, strings.TrimPrefix(action, "snapshots/"))
+		}
 		return notifications.GetNotification(req, repo)
 	case "POST":
-		return notifications.NewNotification(req, repo)
+		if action == "extract" {
+			return audiences.ExtractAudience(req, repo, s3Client, lambdaClient, id)
+		}
+		if action == "explain" {
+			return notifications.ExplainNotification(req, repo, id)
+		}
+		if action == "render" {
+			return rendering.RenderNotification(req, repo, layoutRepo, linkRepo, id)
+		}
+		if action == "test-send" {
+			return delivery.TestSendNotification(req, repo, layoutRepo, linkRepo, messageRepo, id)
+		}
+		if action == "send" {
+			return delivery.SendNotification(req, repo, datasetRepo, layoutRepo, linkRepo, messageRepo, idempotencyRepo, id)
+		}
+		if action == "audience" {
+			return audiences.ResolveAudience(req, repo, datasetRepo, snapshotRepo, id)
+		}
+		if strings.HasPrefix(action, "extractions/") && strings.HasSuffix(action, "/resume") {
+			extractionId := strings.TrimSuffix(strings.TrimPrefix(action, "extractions/"), "/resume")
+			return audiences.ResumeAudienceExtraction(req, s3Client, lambdaClient, id, extractionId)
+		}
+		return notifications.NewNotification(req, repo, datasetRepo)
 	case "PUT":
-		return notifications.SaveNotification(req, repo)
+		return notifications.SaveNotification(req, repo, datasetRepo)
 	case "DELETE":
+		if action == "snapshots" || strings.HasPrefix(action, "snapshots/") {
+			return audiences.InvalidateSnapshot(req, snapshotRepo, id, strings.TrimPrefix(strings.TrimPrefix(action, "snapshots"), "/"))
+		}
 		return notifications.RemoveNotification(req, repo)
 	default:
 		return handlers.UnhandledMethod()
@@ -0,0 +1,248 @@
+package audiences
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hermes/pkg/datasets"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// chunkWriter encodes the rows of one chunk.
+type chunkWriter interface {
+	Write(row datasets.Row) error
+	Rows() int
+	// Finish returns the encoded chunk. The writer can't be used after.
+	Finish() ([]byte, error)
+}
+
+func newChunkWriter(m *Manifest) chunkWriter {
+	if m.Format == FormatParquet {
+		return &parquetChunk{manifest: m}
+	}
+
+	return newNDJSONChunk()
+}
+
+func chunkExtension(format string) string {
+	if format == FormatParquet {
+		return "parquet"
+	}
+
+	return "ndjson"
+}
+
+// ndjsonChunk writes one JSON object per line.
+type ndjsonChunk struct {
+	buffer  bytes.Buffer
+	encoder *json.Encoder
+	rows    int
+}
+
+func newNDJSONChunk() *ndjsonChunk {
+	c := &ndjsonChunk{}
+	c.encoder = json.NewEncoder(&c.buffer)
+
+	return c
+}
+
+func (c *ndjsonChunk) Write(row datasets.Row) error {
+	c.rows++
+	return c.encoder.Encode(row)
+}
+
+func (c *ndjsonChunk) Rows() int {
+	return c.rows
+}
+
+func (c *ndjsonChunk) Finish() ([]byte, error) {
+	return c.buffer.Bytes(), nil
+}
+
+// parquetChunk buffers its rows, since the schema of an extraction is
+// inferred from the rows of its first chunk. Later chunks reuse that
+// schema; values that don't fit the type of their column are written as
+// null and columns missing from it are left out.
+type parquetChunk struct {
+	manifest *Manifest
+	rows     []datasets.Row
+}
+
+func (c *parquetChunk) Write(row datasets.Row) error {
+	c.rows = append(c.rows, row)
+	return nil
+}
+
+func (c *parquetChunk) Rows() int {
+	return len(c.rows)
+}
+
+func (c *parquetChunk) Finish() ([]byte, error) {
+	if len(c.manifest.Columns) == 0 {
+		c.manifest.Columns = inferColumns(c.rows)
+	}
+	columns := c.manifest.Columns
+
+	var buffer bytes.Buffer
+	w, err := writer.NewJSONWriterFromWriter(parquetSchema(columns), &buffer, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range c.rows {
+		record := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			record[parquetFieldName(i)] = parquetValue(row[column.Name], column.Type)
+		}
+
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.Write(string(encoded)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.WriteStop(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// inferColumns types each column from its first non-null value.
+func inferColumns(rows []datasets.Row) []datasets.Column {
+	types := map[string]string{}
+	for _, row := range rows {
+		for name, value := range row {
+			if len(types[name]) == 0 {
+				types[name] = valueType(value)
+			}
+		}
+	}
+
+	columns := make([]datasets.Column, 0, len(types))
+	for name, t := range types {
+		if len(t) == 0 {
+			t = datasets.ColumnTypeString
+		}
+		columns = append(columns, datasets.Column{Name: name, Type: t})
+	}
+	sort.Slice(columns, func(i, j int) bool { return columns[i].Name < columns[j].Name })
+
+	return columns
+}
+
+func valueType(value interface{}) string {
+	switch value.(type) {
+	case int, int32, int64:
+		return datasets.ColumnTypeInteger
+	case float32, float64:
+		return datasets.ColumnTypeNumber
+	case bool:
+		return datasets.ColumnTypeBoolean
+	case time.Time:
+		return datasets.ColumnTypeDate
+	case nil:
+		return ""
+	}
+
+	return datasets.ColumnTypeString
+}
+
+// parquetFieldName is the name a column goes by in the JSON records handed
+// to the writer, as result columns may hold any character.
+func parquetFieldName(index int) string {
+	return fmt.Sprintf("Col_%d", index)
+}
+
+func parquetSchema(columns []datasets.Column) string {
+	fields := make([]string, len(columns))
+	for i, column := range columns {
+		name := strings.NewReplacer(",", "_", "=", "_").Replace(column.Name)
+
+		var physical string
+		switch column.Type {
+		case datasets.ColumnTypeInteger:
+			physical = "type=INT64"
+		case datasets.ColumnTypeNumber:
+			physical = "type=DOUBLE"
+		case datasets.ColumnTypeBoolean:
+			physical = "type=BOOLEAN"
+		case datasets.ColumnTypeDate:
+			physical = "type=INT64, convertedtype=TIMESTAMP_MILLIS"
+		default:
+			physical = "type=BYTE_ARRAY, convertedtype=UTF8"
+		}
+
+		tag, _ := json.Marshal(fmt.Sprintf("name=%s, inname=%s, %s, repetitiontype=OPTIONAL", name, parquetFieldName(i), physical))
+		fields[i] = fmt.Sprintf(`{"Tag":%s}`, tag)
+	}
+
+	return fmt.Sprintf(`{"Tag":"name=audience, repetitiontype=REQUIRED","Fields":[%s]}`, strings.Join(fields, ","))
+}
+
+func parquetValue(value interface{}, columnType string) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	switch columnType {
+	case datasets.ColumnTypeInteger:
+		switch v := value.(type) {
+		case int:
+			return int64(v)
+		case int32:
+			return int64(v)
+		case int64:
+			return v
+		case float64:
+			if v == math.Trunc(v) {
+				return int64(v)
+			}
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i
+			}
+		}
+	case datasets.ColumnTypeNumber:
+		switch v := value.(type) {
+		case float64, float32, int, int32, int64:
+			return v
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+	case datasets.ColumnTypeBoolean:
+		if b, ok := value.(bool); ok {
+			return b
+		}
+	case datasets.ColumnTypeDate:
+		if t, ok := value.(time.Time); ok {
+			return t.UnixNano() / int64(time.Millisecond)
+		}
+	default:
+		if s, ok := value.(string); ok {
+			return s
+		}
+		if t, ok := value.(time.Time); ok {
+			return t.Format(time.RFC3339Nano)
+		}
+		return fmt.Sprint(value)
+	}
+
+	return nil
+}
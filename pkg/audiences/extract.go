@@ -0,0 +1,502 @@
+package audiences
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/common/redact"
+	"hermes/pkg/datasets"
+	"hermes/pkg/notifications"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	ExtractionPending   = "pending"
+	ExtractionRunning   = "running"
+	ExtractionCompleted = "completed"
+	ExtractionFailed    = "failed"
+)
+
+var (
+	AudienceBucket          = os.Getenv("AUDIENCE_BUCKET")
+	ExtractorFunctionName   = os.Getenv("EXTRACTOR_FUNCTION_NAME")
+	DefaultChunkSize        = 50000
+	MaxChunkSize            = 1000000
+	ExtractionSafetyMargin  = 30 * time.Second
+	ExtractionLeaseDuration = 15 * time.Minute
+	ErrorInvalidExtraction  = "invalid extraction request"
+	ErrorInvalidFormat      = "invalid format. Only ndjson and parquet are supported"
+	ErrorInvalidChunkSize   = "invalid chunk size"
+	ErrorMissingBucket      = "AUDIENCE_BUCKET is not configured"
+	ErrorExtractionNotFound = "extraction does not exist"
+	ErrorCouldNotStoreChunk = "could not store audience chunk in S3"
+	ErrorCouldNotSaveRun    = "could not save extraction manifest in S3"
+	ErrorCouldNotStartRun   = "could not start extraction run"
+	ErrorNotificationQuery  = "notification has no dataset query"
+	ErrorExtractionRunning  = "extraction is running. It can be resumed once its run has stopped"
+)
+
+// errOutOfTime stops a stream at a chunk boundary when the lambda is about
+// to time out.
+var errOutOfTime = errors.New("extraction run is out of time")
+
+type ExtractRequest struct {
+	Format    string                 `json:"format"`
+	ChunkSize int                    `json:"chunkSize"`
+	Inputs    map[string]interface{} `json:"inputs"`
+}
+
+// Chunk is one object of an extraction, holding Rows rows.
+type Chunk struct {
+	Index int    `json:"index"`
+	Key   string `json:"key"`
+	Rows  int    `json:"rows"`
+	Bytes int    `json:"bytes"`
+}
+
+// Manifest describes an extraction and is stored next to its chunks. It is
+// rewritten after every chunk, so RowCount is always the number of rows
+// already in S3 and a new run resumes from there.
+type Manifest struct {
+	ExtractionId   string                 `json:"extractionId"`
+	NotificationId string                 `json:"notificationId"`
+	DatasetId      string                 `json:"datasetId"`
+	Format         string                 `json:"format"`
+	ChunkSize      int                    `json:"chunkSize"`
+	Inputs         map[string]interface{} `json:"inputs,omitempty"`
+	Bucket         string                 `json:"bucket"`
+	Prefix         string                 `json:"prefix"`
+	Status         string                 `json:"status"`
+	Columns        []datasets.Column      `json:"columns,omitempty"`
+	// Recipients tells which columns of the chunks hold each recipient
+	// field, when the notification has a recipient mapping.
+	Recipients  *datasets.RecipientMapping `json:"recipients,omitempty"`
+	Chunks      []Chunk                    `json:"chunks"`
+	RowCount    int                        `json:"rowCount"`
+	Runs        int                        `json:"runs"`
+	CreatedAt   string                     `json:"createdAt"`
+	UpdatedAt   string                     `json:"updatedAt"`
+	CompletedAt string                     `json:"completedAt,omitempty"`
+	Error       string                     `json:"error,omitempty"`
+	// RunToken is the run that holds the extraction until LeaseExpiresAt.
+	// Another run or a resume only takes the extraction over once the
+	// lease has expired.
+	RunToken       string `json:"runToken,omitempty"`
+	LeaseExpiresAt string `json:"leaseExpiresAt,omitempty"`
+
+	// etag is the version of the manifest that was loaded, so saving it
+	// fails if another run wrote it in between.
+	etag string
+}
+
+// ExtractionEvent is the payload the extractor lambda is invoked with.
+type ExtractionEvent struct {
+	NotificationId string `json:"notificationId"`
+	ExtractionId   string `json:"extractionId"`
+	RunToken       string `json:"runToken,omitempty"`
+}
+
+// leased tells whether a run holds the extraction.
+func (m *Manifest) leased(now time.Time) bool {
+	until, err := time.Parse(time.RFC3339, m.LeaseExpiresAt)
+	return err == nil && now.Before(until)
+}
+
+// lease hands the extraction to a new run until the given time and
+// returns its token.
+func (m *Manifest) lease(until time.Time) string {
+	m.RunToken = newRunToken()
+	m.LeaseExpiresAt = until.UTC().Format(time.RFC3339)
+
+	return m.RunToken
+}
+
+func (m *Manifest) release() {
+	m.RunToken = ""
+	m.LeaseExpiresAt = ""
+}
+
+func extractionPrefix(notificationId string, extractionId string) string {
+	return fmt.Sprintf("audiences/%s/%s", notificationId, extractionId)
+}
+
+func manifestKey(notificationId string, extractionId string) string {
+	return extractionPrefix(notificationId, extractionId) + "/manifest.json"
+}
+
+// StartExtraction validates the request, writes a pending manifest and
+// hands the extraction over to the extractor lambda.
+func StartExtraction(req events.APIGatewayProxyRequest, repo crud.CrudRepository, s3Client s3iface.S3API, lambdaClient lambdaiface.LambdaAPI, id string) (
+	*Manifest,
+	error,
+) {
+	r := ExtractRequest{}
+	if len(req.Body) > 0 {
+		if err := json.Unmarshal([]byte(req.Body), &r); err != nil {
+			return nil, errors.New(ErrorInvalidExtraction)
+		}
+	}
+
+	if len(AudienceBucket) == 0 {
+		return nil, errors.New(ErrorMissingBucket)
+	}
+
+	n, _ := notifications.FetchNotification(id, repo)
+	if n != nil && len(n.Name) == 0 {
+		return nil, errors.New(notifications.ErrorNotificationDoesNotExists)
+	}
+
+	if len(n.Query.DataSetId) == 0 || len(n.Query.Query) == 0 {
+		return nil, errors.New(ErrorNotificationQuery)
+	}
+
+	if len(r.Format) == 0 {
+		r.Format = FormatNDJSON
+	}
+	if r.Format != FormatNDJSON && r.Format != FormatParquet {
+		return nil, errors.New(ErrorInvalidFormat)
+	}
+
+	if r.ChunkSize == 0 {
+		r.ChunkSize = DefaultChunkSize
+	}
+	if r.ChunkSize < 0 || r.ChunkSize > MaxChunkSize {
+		return nil, fmt.Errorf("%s. It must be between 1 and %d", ErrorInvalidChunkSize, MaxChunkSize)
+	}
+
+	// defaults are fixed when the extraction starts
+	inputs, err := n.Inputs.Resolve(r.Inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	extractionId := newExtractionId(now)
+	m := &Manifest{
+		ExtractionId:   extractionId,
+		NotificationId: n.Id,
+		DatasetId:      n.Query.DataSetId,
+		Format:         r.Format,
+		ChunkSize:      r.ChunkSize,
+		Inputs:         inputs,
+		Bucket:         AudienceBucket,
+		Prefix:         extractionPrefix(n.Id, extractionId),
+		Status:         ExtractionPending,
+		Chunks:         []Chunk{},
+		CreatedAt:      now.Format(time.RFC3339),
+		UpdatedAt:      now.Format(time.RFC3339),
+	}
+	token := m.lease(now.Add(ExtractionLeaseDuration))
+
+	if err := SaveManifest(s3Client, m); err != nil {
+		return nil, err
+	}
+
+	// without a run the extraction is left to be resumed
+	if err := InvokeExtractor(lambdaClient, ExtractionEvent{NotificationId: n.Id, ExtractionId: extractionId, RunToken: token}); err != nil {
+		m.release()
+		SaveManifest(s3Client, m)
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// ResumeExtraction starts a new run of an extraction that failed or whose
+// run died, from the last chunk stored. It is refused while a run holds
+// the extraction.
+func ResumeExtraction(s3Client s3iface.S3API, lambdaClient lambdaiface.LambdaAPI, id string, extractionId string) (*Manifest, error) {
+	m, err := LoadManifest(s3Client, AudienceBucket, id, extractionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Status == ExtractionCompleted {
+		return m, nil
+	}
+
+	now := time.Now()
+	if m.leased(now) {
+		return nil, errors.New(ErrorExtractionRunning)
+	}
+
+	if m.Status == ExtractionFailed {
+		m.Status = ExtractionRunning
+		m.Error = ""
+	}
+	token := m.lease(now.Add(ExtractionLeaseDuration))
+
+	// a concurrent resume saved the manifest first and holds it now
+	if err := SaveManifest(s3Client, m); err != nil {
+		return nil, err
+	}
+
+	if err := InvokeExtractor(lambdaClient, ExtractionEvent{NotificationId: id, ExtractionId: extractionId, RunToken: token}); err != nil {
+		m.release()
+		SaveManifest(s3Client, m)
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// InvokeExtractor starts a run of the extractor lambda without waiting for
+// it to finish.
+func InvokeExtractor(lambdaClient lambdaiface.LambdaAPI, event ExtractionEvent) error {
+	payload, _ := json.Marshal(event)
+	_, err := lambdaClient.Invoke(&lambda.InvokeInput{
+		FunctionName:   aws.String(ExtractorFunctionName),
+		InvocationType: aws.String(lambda.InvocationTypeEvent),
+		Payload:        payload,
+	})
+	if err != nil {
+		redact.Println(err)
+		return errors.New(ErrorCouldNotStartRun)
+	}
+
+	return nil
+}
+
+// RunExtraction streams the audience of an extraction into S3, from where
+// its manifest says the last run stopped. The run first claims the
+// extraction, and does nothing if another run holds it. It stops at a
+// chunk boundary before ctx runs out and hands the extraction over to a
+// new run.
+func RunExtraction(ctx context.Context, event ExtractionEvent, repo crud.CrudRepository, datasetRepo crud.CrudRepository, s3Client s3iface.S3API, lambdaClient lambdaiface.LambdaAPI) (
+	*Manifest,
+	error,
+) {
+	m, err := LoadManifest(s3Client, AudienceBucket, event.NotificationId, event.ExtractionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Status == ExtractionCompleted || m.Status == ExtractionFailed {
+		return m, nil
+	}
+
+	now := time.Now()
+	if m.RunToken != event.RunToken && m.leased(now) {
+		redact.Println("extraction", m.ExtractionId, "is held by another run")
+		return m, nil
+	}
+
+	until := now.Add(ExtractionLeaseDuration)
+	if deadline, ok := ctx.Deadline(); ok {
+		until = deadline
+	}
+	m.lease(until)
+	m.Status = ExtractionRunning
+	m.Runs++
+
+	// the claim fails if another run claimed the extraction since it was
+	// loaded
+	if err := SaveManifest(s3Client, m); err != nil {
+		return nil, err
+	}
+
+	err = extract(ctx, m, repo, datasetRepo, s3Client)
+	if err == errOutOfTime {
+		next := ExtractionEvent{NotificationId: m.NotificationId, ExtractionId: m.ExtractionId}
+		next.RunToken = m.lease(time.Now().Add(ExtractionLeaseDuration))
+		if err := SaveManifest(s3Client, m); err != nil {
+			return nil, err
+		}
+		// the extraction fails below when no run takes it over
+		if err = InvokeExtractor(lambdaClient, next); err == nil {
+			return m, nil
+		}
+	}
+
+	if err != nil {
+		redact.Println("extraction", m.ExtractionId, "failed:", err)
+		m.Status = ExtractionFailed
+		m.Error = redact.String(err.Error())
+	}
+	m.release()
+
+	if err := SaveManifest(s3Client, m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func extract(ctx context.Context, m *Manifest, repo crud.CrudRepository, datasetRepo crud.CrudRepository, s3Client s3iface.S3API) error {
+	n, _ := notifications.FetchNotification(m.NotificationId, repo)
+	if n != nil && len(n.Name) == 0 {
+		return errors.New(notifications.ErrorNotificationDoesNotExists)
+	}
+
+	args, err := BindInputs(n.Inputs, m.Inputs)
+	if err != nil {
+		return err
+	}
+
+	d, _ := datasets.FetchDataset(m.DatasetId, datasetRepo)
+	if d != nil && len(d.Name) == 0 {
+		return errors.New(datasets.ErrorDatasetDoesNotExists)
+	}
+
+	if m.RowCount == 0 {
+		m.Recipients = n.RecipientMapping(d)
+		if err := datasets.CheckRecipientColumns(d, m.Recipients, n.Query.Query, args); err != nil {
+			return err
+		}
+	}
+
+	source, err := datasets.OpenDataset(d)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	deadline, hasDeadline := ctx.Deadline()
+	chunk := newChunkWriter(m)
+
+	err = datasets.StreamRows(source, n.Query.Query, args, m.RowCount, func(row datasets.Row) error {
+		if err := chunk.Write(row); err != nil {
+			return err
+		}
+		if chunk.Rows() < m.ChunkSize {
+			return nil
+		}
+
+		if err := flushChunk(s3Client, m, chunk); err != nil {
+			return err
+		}
+		chunk = newChunkWriter(m)
+
+		if hasDeadline && time.Until(deadline) < ExtractionSafetyMargin {
+			return errOutOfTime
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if chunk.Rows() > 0 {
+		if err := flushChunk(s3Client, m, chunk); err != nil {
+			return err
+		}
+	}
+
+	m.Status = ExtractionCompleted
+	m.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+
+	return nil
+}
+
+// flushChunk stores a full chunk and checkpoints the manifest, so a run
+// that is cut short resumes after it.
+func flushChunk(s3Client s3iface.S3API, m *Manifest, chunk chunkWriter) error {
+	data, err := chunk.Finish()
+	if err != nil {
+		return err
+	}
+
+	index := len(m.Chunks)
+	key := fmt.Sprintf("%s/chunk-%05d.%s", m.Prefix, index, chunkExtension(m.Format))
+	_, err = s3Client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(m.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		redact.Println(err)
+		return errors.New(ErrorCouldNotStoreChunk)
+	}
+
+	m.Chunks = append(m.Chunks, Chunk{Index: index, Key: key, Rows: chunk.Rows(), Bytes: len(data)})
+	m.RowCount += chunk.Rows()
+
+	return SaveManifest(s3Client, m)
+}
+
+// SaveManifest writes the manifest only if it wasn't written since it was
+// loaded, and fails with ErrorExtractionRunning otherwise.
+func SaveManifest(s3Client s3iface.S3API, m *Manifest) error {
+	m.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	req, output := s3Client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(m.Bucket),
+		Key:         aws.String(manifestKey(m.NotificationId, m.ExtractionId)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if len(m.etag) > 0 {
+		req.HTTPRequest.Header.Set("If-Match", m.etag)
+	} else {
+		req.HTTPRequest.Header.Set("If-None-Match", "*")
+	}
+	if err := req.Send(); err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); ok && (aerr.StatusCode() == http.StatusPreconditionFailed || aerr.StatusCode() == http.StatusConflict) {
+			return errors.New(ErrorExtractionRunning)
+		}
+		redact.Println(err)
+		return errors.New(ErrorCouldNotSaveRun)
+	}
+	m.etag = aws.StringValue(output.ETag)
+
+	return nil
+}
+
+func LoadManifest(s3Client s3iface.S3API, bucket string, notificationId string, extractionId string) (*Manifest, error) {
+	object, err := s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(manifestKey(notificationId, extractionId)),
+	})
+	if err != nil {
+		redact.Println(err)
+		return nil, errors.New(ErrorExtractionNotFound)
+	}
+	defer object.Body.Close()
+
+	data, err := ioutil.ReadAll(object.Body)
+	if err != nil {
+		return nil, errors.New(ErrorExtractionNotFound)
+	}
+
+	m := &Manifest{etag: aws.StringValue(object.ETag)}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.New(ErrorExtractionNotFound)
+	}
+
+	return m, nil
+}
+
+// newExtractionId sorts by creation time.
+func newExtractionId(now time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+func newRunToken() string {
+	token := make([]byte, 8)
+	rand.Read(token)
+
+	return hex.EncodeToString(token)
+}
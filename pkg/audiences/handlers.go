@@ -0,0 +1,185 @@
+package audiences
+
+import (
+	"encoding/json"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/datasets"
+	"hermes/pkg/handlers"
+	"hermes/pkg/notifications"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+type ErrorBody struct {
+	ErrorMsg *string `json:"error,omitempty"`
+}
+
+func ExtractAudience(req events.APIGatewayProxyRequest, repo crud.CrudRepository, s3Client s3iface.S3API, lambdaClient lambdaiface.LambdaAPI, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := StartExtraction(req, repo, s3Client, lambdaClient, id)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusAccepted, result)
+}
+
+func GetExtraction(req events.APIGatewayProxyRequest, s3Client s3iface.S3API, id string, extractionId string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := LoadManifest(s3Client, AudienceBucket, id, extractionId)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusNotFound, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, result)
+}
+
+func ResumeAudienceExtraction(req events.APIGatewayProxyRequest, s3Client s3iface.S3API, lambdaClient lambdaiface.LambdaAPI, id string, extractionId string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := ResumeExtraction(s3Client, lambdaClient, id, extractionId)
+	if err != nil {
+		if err.Error() == ErrorExtractionRunning {
+			return handlers.ApiResponse(http.StatusConflict, ErrorBody{
+				aws.String(err.Error()),
+			})
+		}
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusAccepted, result)
+}
+
+type SnapshotDetail struct {
+	Snapshot *Snapshot      `json:"snapshot"`
+	Rows     []datasets.Row `json:"rows"`
+}
+
+type InvalidatedSnapshots struct {
+	Invalidated int `json:"invalidated"`
+}
+
+// ResolveAudience returns the audience of a notification, reusing a live
+// snapshot when there is one. Only the first "limit" rows are returned.
+func ResolveAudience(req events.APIGatewayProxyRequest, repo crud.CrudRepository, datasetRepo crud.CrudRepository, snapshots *SnapshotRepository, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	r := AudienceRequest{}
+	if len(req.Body) > 0 {
+		if err := json.Unmarshal([]byte(req.Body), &r); err != nil {
+			return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+				aws.String(ErrorInvalidAudienceData),
+			})
+		}
+	}
+
+	n, _ := notifications.FetchNotification(id, repo)
+	if n != nil && len(n.Name) == 0 {
+		return handlers.ApiResponse(http.StatusNotFound, ErrorBody{
+			aws.String(notifications.ErrorNotificationDoesNotExists),
+		})
+	}
+
+	result, err := FetchAudience(n, r.Inputs, r.Fresh, datasetRepo, snapshots)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+
+	if limit := rowLimit(req, 100); len(result.Rows) > limit {
+		result.Rows = result.Rows[:limit]
+		if len(result.Recipients) > limit {
+			result.Recipients = result.Recipients[:limit]
+		}
+	}
+	return handlers.ApiResponse(http.StatusOK, result)
+}
+
+func ListSnapshots(req events.APIGatewayProxyRequest, snapshots *SnapshotRepository, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := snapshots.List(id, time.Now())
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, result)
+}
+
+func InspectSnapshot(req events.APIGatewayProxyRequest, snapshots *SnapshotRepository, id string, hash string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	s, err := FetchSnapshot(snapshots, id, hash)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusNotFound, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+
+	rows, err := snapshots.ReadRows(s, rowLimit(req, 20))
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, SnapshotDetail{Snapshot: s, Rows: rows})
+}
+
+// InvalidateSnapshot deletes one snapshot, or all the snapshots of the
+// notification when hash is empty.
+func InvalidateSnapshot(req events.APIGatewayProxyRequest, snapshots *SnapshotRepository, id string, hash string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	if len(hash) == 0 {
+		count, err := InvalidateSnapshots(snapshots, id)
+		if err != nil {
+			return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+				aws.String(err.Error()),
+			})
+		}
+		return handlers.ApiResponse(http.StatusOK, InvalidatedSnapshots{Invalidated: count})
+	}
+
+	s, err := FetchSnapshot(snapshots, id, hash)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusNotFound, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+
+	if err := snapshots.Invalidate(s); err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, InvalidatedSnapshots{Invalidated: 1})
+}
+
+func rowLimit(req events.APIGatewayProxyRequest, fallback int) int {
+	limit, err := strconv.Atoi(req.QueryStringParameters["limit"])
+	if err != nil || limit <= 0 {
+		return fallback
+	}
+
+	return limit
+}
@@ -0,0 +1,23 @@
+package audiences
+
+import (
+	"hermes/pkg/notifications"
+)
+
+// BindInputs checks values against the inputs of the notification and
+// returns them in the order they are declared, which is the order of the
+// query placeholders ($1, $2...). Optional inputs left out are bound as
+// nulls.
+func BindInputs(inputs notifications.Inputs, values map[string]interface{}) ([]interface{}, error) {
+	resolved, err := inputs.Resolve(values)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]interface{}, 0, len(inputs))
+	for _, in := range inputs {
+		args = append(args, resolved[in.Name])
+	}
+
+	return args, nil
+}
@@ -0,0 +1,452 @@
+package audiences
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hermes/pkg/common/crud"
+	BaseErrors "hermes/pkg/common/errors"
+	"hermes/pkg/common/redact"
+	"hermes/pkg/datasets"
+	"hermes/pkg/notifications"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+var (
+	DefaultSnapshotTTL       = 15 * time.Minute
+	DefaultSnapshotMaxRows   = 100000
+	ErrorSnapshotNotFound    = "snapshot does not exist"
+	ErrorSnapshotTooLarge    = "audience is too large for a snapshot. Use an extraction instead"
+	ErrorCouldNotStoreRows   = "could not store snapshot rows in S3"
+	ErrorCouldNotReadRows    = "could not read snapshot rows from S3"
+	ErrorInvalidAudienceData = "invalid audience request"
+)
+
+// Snapshot is the materialised audience of a notification query for one
+// set of inputs. Hash identifies the notification, the dataset as it is
+// configured, the query and the bound inputs, so runs of the notification
+// asking for the same audience within the TTL share it. Rows are kept
+// in S3 and the record expires through the table TTL on expiresAt.
+type Snapshot struct {
+	Hash           string                 `json:"hash"`
+	NotificationId string                 `json:"notificationId"`
+	DatasetId      string                 `json:"datasetId"`
+	Query          string                 `json:"query"`
+	Inputs         map[string]interface{} `json:"inputs,omitempty"`
+	RowCount       int                    `json:"rowCount"`
+	Bucket         string                 `json:"bucket"`
+	Key            string                 `json:"key"`
+	CreatedAt      string                 `json:"createdAt"`
+	ExpiresAt      int64                  `json:"expiresAt"`
+	Hits           int                    `json:"hits"`
+	LastUsedAt     string                 `json:"lastUsedAt,omitempty"`
+}
+
+func (s Snapshot) Expired(now time.Time) bool {
+	return now.Unix() >= s.ExpiresAt
+}
+
+type AudienceRequest struct {
+	Inputs map[string]interface{} `json:"inputs"`
+	// Fresh skips any stored snapshot and replaces it.
+	Fresh bool `json:"fresh"`
+}
+
+// Audience is the result of resolving the audience of a notification.
+type Audience struct {
+	Snapshot *Snapshot      `json:"snapshot"`
+	Reused   bool           `json:"reused"`
+	Rows     []datasets.Row `json:"rows"`
+	// Recipients holds the rows resolved through the recipient mapping of
+	// the notification, when it has one.
+	Recipients []datasets.Recipient `json:"recipients,omitempty"`
+}
+
+// SnapshotRepository stores snapshots in a table keyed by hash.
+type SnapshotRepository struct {
+	dynaClient dynamodbiface.DynamoDBAPI
+	s3Client   s3iface.S3API
+	tableName  string
+}
+
+func InitSnapshotRepo(t string, d dynamodbiface.DynamoDBAPI, s3Client s3iface.S3API) *SnapshotRepository {
+	return &SnapshotRepository{
+		dynaClient: d,
+		s3Client:   s3Client,
+		tableName:  t,
+	}
+}
+
+func (r *SnapshotRepository) Get(hash string) (*Snapshot, error) {
+	result, err := r.dynaClient.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"hash": {S: aws.String(hash)},
+		},
+	})
+	if err != nil {
+		redact.Println(err)
+		return nil, errors.New(BaseErrors.ErrorFailedToFetchRecord)
+	}
+
+	if len(result.Item) == 0 {
+		return nil, errors.New(ErrorSnapshotNotFound)
+	}
+
+	s := &Snapshot{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, s); err != nil {
+		return nil, errors.New(BaseErrors.ErrorFailedToUnmarshalRecord)
+	}
+
+	return s, nil
+}
+
+func (r *SnapshotRepository) Put(s *Snapshot) error {
+	av, err := dynamodbattribute.MarshalMap(s)
+	if err != nil {
+		return errors.New(BaseErrors.ErrorCouldNotMarshalItem)
+	}
+
+	_, err = r.dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(r.tableName),
+	})
+	if err != nil {
+		redact.Println(err)
+		return errors.New(BaseErrors.ErrorCouldNotDynamoPutItem)
+	}
+
+	return nil
+}
+
+// List returns the live snapshots of a notification.
+func (r *SnapshotRepository) List(notificationId string, now time.Time) ([]Snapshot, error) {
+	snapshots := []Snapshot{}
+	err := r.dynaClient.ScanPages(&dynamodb.ScanInput{
+		TableName:        aws.String(r.tableName),
+		FilterExpression: aws.String("notificationId = :id AND expiresAt > :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id":  {S: aws.String(notificationId)},
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		items := []Snapshot{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(page.Items, &items); err == nil {
+			snapshots = append(snapshots, items...)
+		}
+		return true
+	})
+	if err != nil {
+		redact.Println(err)
+		return nil, errors.New(BaseErrors.ErrorFailedToFetchRecord)
+	}
+
+	return snapshots, nil
+}
+
+// Invalidate deletes a snapshot and its rows.
+func (r *SnapshotRepository) Invalidate(s *Snapshot) error {
+	_, err := r.dynaClient.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"hash": {S: aws.String(s.Hash)},
+		},
+	})
+	if err != nil {
+		redact.Println(err)
+		return errors.New(BaseErrors.ErrorCouldNotDeleteItem)
+	}
+
+	r.s3Client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(s.Bucket), Key: aws.String(s.Key)})
+
+	return nil
+}
+
+// touch counts a reuse of the snapshot.
+func (r *SnapshotRepository) touch(s *Snapshot, now time.Time) {
+	s.Hits++
+	s.LastUsedAt = now.UTC().Format(time.RFC3339)
+
+	_, err := r.dynaClient.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"hash": {S: aws.String(s.Hash)},
+		},
+		UpdateExpression: aws.String("ADD hits :one SET lastUsedAt = :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+			":now": {S: aws.String(s.LastUsedAt)},
+		},
+	})
+	if err != nil {
+		redact.Println("could not count reuse of snapshot", s.Hash, err)
+	}
+}
+
+func (r *SnapshotRepository) storeRows(s *Snapshot, rows []datasets.Row) error {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, row := range rows {
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	_, err := r.s3Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(s.Key),
+		Body:        bytes.NewReader(buffer.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		redact.Println(err)
+		return errors.New(ErrorCouldNotStoreRows)
+	}
+
+	return nil
+}
+
+// ReadRows returns up to limit rows of a snapshot, or all of them when
+// limit is zero.
+func (r *SnapshotRepository) ReadRows(s *Snapshot, limit int) ([]datasets.Row, error) {
+	object, err := r.s3Client.GetObject(&s3.GetObjectInput{Bucket: aws.String(s.Bucket), Key: aws.String(s.Key)})
+	if err != nil {
+		redact.Println(err)
+		return nil, errors.New(ErrorCouldNotReadRows)
+	}
+	defer object.Body.Close()
+
+	rows := []datasets.Row{}
+	scanner := bufio.NewScanner(object.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if limit > 0 && len(rows) >= limit {
+			break
+		}
+		row := datasets.Row{}
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			return nil, errors.New(ErrorCouldNotReadRows)
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New(ErrorCouldNotReadRows)
+	}
+
+	return rows, nil
+}
+
+// FetchSnapshot returns a live snapshot of the notification.
+func FetchSnapshot(snapshots *SnapshotRepository, notificationId string, hash string) (*Snapshot, error) {
+	s, err := snapshots.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.NotificationId != notificationId || s.Expired(time.Now()) {
+		return nil, errors.New(ErrorSnapshotNotFound)
+	}
+
+	return s, nil
+}
+
+// InvalidateSnapshots deletes every live snapshot of the notification and
+// returns how many there were.
+func InvalidateSnapshots(snapshots *SnapshotRepository, notificationId string) (int, error) {
+	all, err := snapshots.List(notificationId, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range all {
+		if err := snapshots.Invalidate(&all[i]); err != nil {
+			return i, err
+		}
+	}
+
+	return len(all), nil
+}
+
+// SnapshotHash identifies an audience by the notification, the dataset and
+// its fingerprint, the query and the inputs bound to it. Snapshots are
+// never shared across notifications, and a dataset whose connection or
+// credentials changed gets new ones.
+func SnapshotHash(notificationId string, d *datasets.DataSet, query string, args []interface{}) string {
+	key, _ := json.Marshal(struct {
+		NotificationId string        `json:"notificationId"`
+		DatasetId      string        `json:"datasetId"`
+		Dataset        string        `json:"dataset"`
+		Query          string        `json:"query"`
+		Args           []interface{} `json:"args"`
+	}{notificationId, d.Id, d.Fingerprint(), strings.TrimSpace(query), args})
+	sum := sha256.Sum256(key)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// FetchAudience returns the rows a notification query yields for inputs.
+// A live snapshot of the same audience is reused; otherwise the query is
+// run against the dataset and its result is stored as a new snapshot.
+func FetchAudience(n *notifications.Notification, inputs map[string]interface{}, fresh bool, datasetRepo crud.CrudRepository, snapshots *SnapshotRepository) (
+	*Audience,
+	error,
+) {
+	if len(n.Query.DataSetId) == 0 || len(n.Query.Query) == 0 {
+		return nil, errors.New(ErrorNotificationQuery)
+	}
+
+	if len(AudienceBucket) == 0 {
+		return nil, errors.New(ErrorMissingBucket)
+	}
+
+	args, err := BindInputs(n.Inputs, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	d, _ := datasets.FetchDataset(n.Query.DataSetId, datasetRepo)
+	if d != nil && len(d.Name) == 0 {
+		return nil, errors.New(datasets.ErrorDatasetDoesNotExists)
+	}
+	mapping := n.RecipientMapping(d)
+
+	now := time.Now()
+	hash := SnapshotHash(n.Id, d, n.Query.Query, args)
+
+	if !fresh {
+		if s, err := snapshots.Get(hash); err == nil && !s.Expired(now) {
+			rows, err := snapshots.ReadRows(s, 0)
+			if err == nil {
+				snapshots.touch(s, now)
+				return newAudience(s, true, rows, mapping), nil
+			}
+			redact.Println("could not reuse snapshot", hash, err)
+		}
+	}
+
+	if err := datasets.CheckRecipientColumns(d, mapping, n.Query.Query, args); err != nil {
+		return nil, err
+	}
+
+	source, err := datasets.OpenDataset(d)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	maxRows := snapshotMaxRows()
+	rows := []datasets.Row{}
+	err = datasets.StreamRows(source, n.Query.Query, args, 0, func(row datasets.Row) error {
+		if len(rows) >= maxRows {
+			return fmt.Errorf("%s (%d rows)", ErrorSnapshotTooLarge, maxRows)
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snapshot{
+		Hash:           hash,
+		NotificationId: n.Id,
+		DatasetId:      n.Query.DataSetId,
+		Query:          n.Query.Query,
+		Inputs:         inputs,
+		RowCount:       len(rows),
+		Bucket:         AudienceBucket,
+		Key:            fmt.Sprintf("snapshots/%s.ndjson", hash),
+		CreatedAt:      now.UTC().Format(time.RFC3339),
+		ExpiresAt:      now.Add(snapshotTTL()).Unix(),
+	}
+
+	if err := snapshots.storeRows(s, rows); err != nil {
+		return nil, err
+	}
+	if err := snapshots.Put(s); err != nil {
+		return nil, err
+	}
+
+	return newAudience(s, false, rows, mapping), nil
+}
+
+// FindRecipients looks up the recipients of a notification whose field,
+// such as email, is one of values, filtering the query on the mapped
+// column instead of reading the whole audience. It returns nil when the
+// notification has no recipient mapping.
+func FindRecipients(n *notifications.Notification, inputs map[string]interface{}, field string, values []string, datasetRepo crud.CrudRepository) (
+	[]datasets.Recipient,
+	error,
+) {
+	if len(n.Query.DataSetId) == 0 || len(n.Query.Query) == 0 {
+		return nil, errors.New(ErrorNotificationQuery)
+	}
+
+	args, err := BindInputs(n.Inputs, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	d, _ := datasets.FetchDataset(n.Query.DataSetId, datasetRepo)
+	if d != nil && len(d.Name) == 0 {
+		return nil, errors.New(datasets.ErrorDatasetDoesNotExists)
+	}
+
+	mapping := n.RecipientMapping(d)
+	if mapping == nil {
+		return nil, nil
+	}
+
+	column := mapping.Column(field)
+	if len(column) == 0 {
+		return []datasets.Recipient{}, nil
+	}
+
+	source, err := datasets.OpenDataset(d)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	rows, err := datasets.FindRows(source, n.Query.Query, args, column, values, field == "email")
+	if err != nil {
+		return nil, err
+	}
+
+	return datasets.ResolveRecipients(mapping, rows), nil
+}
+
+func newAudience(s *Snapshot, reused bool, rows []datasets.Row, mapping *datasets.RecipientMapping) *Audience {
+	return &Audience{Snapshot: s, Reused: reused, Rows: rows, Recipients: datasets.ResolveRecipients(mapping, rows)}
+}
+
+// snapshotTTL reads AUDIENCE_SNAPSHOT_TTL, in Go duration syntax.
+func snapshotTTL() time.Duration {
+	if v, err := time.ParseDuration(strings.TrimSpace(os.Getenv("AUDIENCE_SNAPSHOT_TTL"))); err == nil && v > 0 {
+		return v
+	}
+
+	return DefaultSnapshotTTL
+}
+
+func snapshotMaxRows() int {
+	if v, err := strconv.Atoi(os.Getenv("AUDIENCE_SNAPSHOT_MAX_ROWS")); err == nil && v > 0 {
+		return v
+	}
+
+	return DefaultSnapshotMaxRows
+}
@@ -3,8 +3,10 @@ package crud
 import (
 	"errors"
 	BaseErrors "hermes/pkg/common/errors"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
@@ -15,6 +17,13 @@ type CrudRepository interface {
 	Get(id string, item interface{}) (interface{}, error)
 	Create(dto interface{}) (interface{}, error)
 	Update(id string, dto interface{}) (interface{}, error)
+	// UpdateAttribute sets a single attribute of an existing item, or
+	// removes it when value is nil, leaving the rest of the item as is.
+	UpdateAttribute(id string, name string, value interface{}) error
+	// UpdateVersion replaces an item only while its version attribute is
+	// still version, so concurrent updates don't overwrite each other. An
+	// item without a version is at version 0.
+	UpdateVersion(id string, dto interface{}, version int) error
 	Delete(id string) error
 }
 
@@ -100,6 +109,65 @@ func (d *DynamoCrud) Update(id string, dto interface{}) (interface{}, error) {
 	return &dto, nil
 }
 
+func (d *DynamoCrud) UpdateAttribute(id string, name string, value interface{}) error {
+	input := &dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		TableName:                aws.String(d.tableName),
+		ConditionExpression:      aws.String("attribute_exists(id)"),
+		ExpressionAttributeNames: map[string]*string{"#attr": aws.String(name)},
+		UpdateExpression:         aws.String("REMOVE #attr"),
+	}
+
+	if value != nil {
+		av, err := dynamodbattribute.Marshal(value)
+		if err != nil {
+			return errors.New(BaseErrors.ErrorCouldNotMarshalItem)
+		}
+		input.UpdateExpression = aws.String("SET #attr = :value")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":value": av}
+	}
+
+	_, err := d.dynaClient.UpdateItem(input)
+	if err != nil {
+		return errors.New(BaseErrors.ErrorCouldNotDynamoPutItem)
+	}
+	return nil
+}
+
+func (d *DynamoCrud) UpdateVersion(id string, dto interface{}, version int) error {
+	av, err := dynamodbattribute.MarshalMap(dto)
+	if err != nil {
+		return errors.New(BaseErrors.ErrorCouldNotMarshalItem)
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:                     av,
+		TableName:                aws.String(d.tableName),
+		ConditionExpression:      aws.String("#version = :version"),
+		ExpressionAttributeNames: map[string]*string{"#version": aws.String("version")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":version": {N: aws.String(strconv.Itoa(version))},
+		},
+	}
+
+	if version == 0 {
+		input.ConditionExpression = aws.String("attribute_not_exists(#version) OR #version = :version")
+	}
+
+	_, err = d.dynaClient.PutItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return errors.New(BaseErrors.ErrorItemVersionChanged)
+		}
+		return errors.New(BaseErrors.ErrorCouldNotDynamoPutItem)
+	}
+	return nil
+}
+
 func (d *DynamoCrud) Delete(id string) error {
 	input := &dynamodb.DeleteItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
@@ -6,4 +6,5 @@ var (
 	ErrorCouldNotMarshalItem     = "could not marshal item"
 	ErrorCouldNotDeleteItem      = "could not delete item"
 	ErrorCouldNotDynamoPutItem   = "could not dynamo put item error"
+	ErrorItemVersionChanged      = "item was changed by another request, fetch it and try again"
 )
@@ -0,0 +1,82 @@
+package redact
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces every secret found in a string.
+const Mask = "****"
+
+var (
+	urlUserInfo = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^:/?#\s"'@]+):([^\s"'/]+)@`)
+	keyValue    = regexp.MustCompile(`(?i)\b(password|passwd|pwd|sslpassword|secret|client_secret|token|access_token|api_key|apikey|signature|x-amz-signature|x-amz-security-token)=([^\s&"',;]+)`)
+	jsonValue   = regexp.MustCompile(`(?i)("(?:password|passwd|secret|clientSecret|access_token|accessToken|api_key|apiKey|privateKey|private_key|passphrase)"\s*:\s*")((?:[^"\\]|\\.)*)"`)
+	sensitive   = regexp.MustCompile(`(?i)(authorization|cookie|token|secret|password|api[-_]?key|signature|credential)`)
+)
+
+// String masks passwords in URLs and DSNs, and secret-looking key=value
+// pairs, anywhere in s.
+func String(s string) string {
+	s = urlUserInfo.ReplaceAllString(s, "$1:"+Mask+"@")
+	s = keyValue.ReplaceAllString(s, "$1="+Mask)
+	s = jsonValue.ReplaceAllString(s, `$1`+Mask+`"`)
+
+	return s
+}
+
+// URL masks the password and any sensitive query parameter of a URL. Values
+// that don't parse as a URL fall back to String.
+func URL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || len(u.Scheme) == 0 {
+		return String(raw)
+	}
+
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), Mask)
+		}
+	}
+
+	query := u.Query()
+	for name := range query {
+		if IsSensitive(name) {
+			query.Set(name, Mask)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	// url.URL escapes the mask, keep it readable
+	return strings.NewReplacer(url.QueryEscape(Mask), Mask, url.PathEscape(Mask), Mask).Replace(u.String())
+}
+
+// IsSensitive reports whether a header or parameter name usually carries a
+// secret.
+func IsSensitive(name string) bool {
+	return sensitive.MatchString(name)
+}
+
+// Headers returns a copy of headers with sensitive values masked.
+func Headers(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+
+	result := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if IsSensitive(k) {
+			v = Mask
+		}
+		result[k] = v
+	}
+
+	return result
+}
+
+// Println is fmt.Println with the output passed through String.
+func Println(a ...interface{}) {
+	fmt.Println(String(strings.TrimSuffix(fmt.Sprintln(a...), "\n")))
+}
@@ -0,0 +1,61 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// EnvSecretPrefix starts the names of the environment variables datasets
+// may refer to, so that the lambda's own configuration, AWS credentials
+// included, can't be read through a dataset.
+var EnvSecretPrefix = "HERMES_SECRET_"
+
+// EnvProvider reads the secret from the environment variable named in
+// DataSet.Credentials. Secrets are managed with the lambda configuration, so
+// they can't be rotated through the API.
+type EnvProvider struct{}
+
+func (EnvProvider) Store(datasetId string, secret string) (string, error) {
+	if !allowedEnvName(secret) {
+		return "", errors.New(ErrorEnvironmentVariableNotAllowed)
+	}
+	if _, ok := os.LookupEnv(secret); !ok {
+		return "", errors.New(ErrorEnvironmentVariableUnset)
+	}
+
+	return secret, nil
+}
+
+func (EnvProvider) Resolve(ref string) (string, string, error) {
+	if !allowedEnvName(ref) {
+		return "", "", errors.New(ErrorEnvironmentVariableNotAllowed)
+	}
+
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", "", errors.New(ErrorEnvironmentVariableUnset)
+	}
+
+	return value, "", nil
+}
+
+func (EnvProvider) Stage(ref string, secret string) (string, error) {
+	return "", errors.New(ErrorRotationNotSupported)
+}
+
+func (EnvProvider) Promote(ref string, staged string) (string, error) {
+	return "", errors.New(ErrorRotationNotSupported)
+}
+
+func (EnvProvider) Discard(staged string) error {
+	return nil
+}
+
+func (EnvProvider) Delete(ref string) error {
+	return nil
+}
+
+func allowedEnvName(name string) bool {
+	return strings.HasPrefix(name, EnvSecretPrefix) && len(name) > len(EnvSecretPrefix)
+}
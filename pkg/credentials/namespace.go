@@ -0,0 +1,21 @@
+package credentials
+
+import (
+	"fmt"
+	"os"
+)
+
+// Environment namespaces stored secrets so several deployments can share an
+// AWS account. It is read from HERMES_ENV.
+var Environment = os.Getenv("HERMES_ENV")
+
+// SecretName returns the hierarchical name the secret of a dataset is
+// stored under, e.g. /hermes/dev/datasets/{id}.
+func SecretName(datasetId string) string {
+	env := Environment
+	if len(env) == 0 {
+		env = "dev"
+	}
+
+	return fmt.Sprintf("/hermes/%s/datasets/%s", env, datasetId)
+}
@@ -0,0 +1,30 @@
+package credentials
+
+// NoneProvider stores the secret as-is in DataSet.Credentials.
+type NoneProvider struct{}
+
+func (NoneProvider) Store(datasetId string, secret string) (string, error) {
+	return secret, nil
+}
+
+func (NoneProvider) Resolve(ref string) (string, string, error) {
+	return ref, "", nil
+}
+
+// Stage keeps the secret inline; nothing reads it until the dataset stores
+// the promoted reference.
+func (NoneProvider) Stage(ref string, secret string) (string, error) {
+	return secret, nil
+}
+
+func (NoneProvider) Promote(ref string, staged string) (string, error) {
+	return staged, nil
+}
+
+func (NoneProvider) Discard(staged string) error {
+	return nil
+}
+
+func (NoneProvider) Delete(ref string) error {
+	return nil
+}
@@ -0,0 +1,73 @@
+package credentials
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrorUnknownProvider               = "invalid provider"
+	ErrorRotationNotSupported          = "provider does not support credential rotation"
+	ErrorCouldNotStoreSecret           = "could not store your credentials securely"
+	ErrorCouldNotResolveSecret         = "could not retrieve securely credentials"
+	ErrorCouldNotDeleteSecret          = "could not delete stored credentials"
+	ErrorInvalidSecretReference        = "invalid credentials reference"
+	ErrorEnvironmentVariableUnset      = "environment variable is not set"
+	ErrorEnvironmentVariableNotAllowed = "environment variable names must start with HERMES_SECRET_"
+)
+
+// CredentialProvider keeps the secret part of a dataset outside of the
+// datasets table. The value saved in DataSet.Credentials is the reference
+// returned by Store or Promote, never the secret itself (except for "none").
+type CredentialProvider interface {
+	// Store saves secret for the dataset and returns its reference.
+	Store(datasetId string, secret string) (string, error)
+	// Resolve returns the secret behind ref and the version it was read from.
+	Resolve(ref string) (string, string, error)
+	// Stage saves secret as a pending version of ref, which ref keeps
+	// ignoring until it is promoted, and returns a reference to it.
+	Stage(ref string, secret string) (string, error)
+	// Promote makes a staged secret the one ref resolves to and returns the
+	// reference to store, pinned to the promoted version.
+	Promote(ref string, staged string) (string, error)
+	// Discard drops a staged secret that is not going to be promoted.
+	Discard(staged string) error
+	// Delete removes every version of the secret behind ref.
+	Delete(ref string) error
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]CredentialProvider{}
+)
+
+func init() {
+	Register("none", NoneProvider{})
+	Register("env", EnvProvider{})
+}
+
+// Register makes a provider available by name. Providers that depend on AWS
+// clients are registered by the lambda entrypoints.
+func Register(name string, provider CredentialProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	providers[name] = provider
+}
+
+func Get(name string) (CredentialProvider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	provider, ok := providers[name]
+	if !ok {
+		return nil, errors.New(ErrorUnknownProvider)
+	}
+
+	return provider, nil
+}
+
+func IsRegistered(name string) bool {
+	_, err := Get(name)
+	return err == nil
+}
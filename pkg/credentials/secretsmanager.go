@@ -0,0 +1,163 @@
+package credentials
+
+import (
+	"errors"
+	"hermes/pkg/common/redact"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+const (
+	stageCurrent = "AWSCURRENT"
+	stagePending = "AWSPENDING"
+)
+
+// SecretsManagerProvider stores secrets in AWS Secrets Manager under
+// SecretName. References may pin a version as "name#versionId". Rotated
+// secrets are staged as AWSPENDING and only become AWSCURRENT once
+// promoted.
+type SecretsManagerProvider struct {
+	client secretsmanageriface.SecretsManagerAPI
+}
+
+func NewSecretsManagerProvider(client secretsmanageriface.SecretsManagerAPI) *SecretsManagerProvider {
+	return &SecretsManagerProvider{client: client}
+}
+
+func (p *SecretsManagerProvider) Store(datasetId string, secret string) (string, error) {
+	name := SecretName(datasetId)
+	_, err := p.client.CreateSecret(&secretsmanager.CreateSecretInput{
+		Name:         aws.String(name),
+		SecretString: aws.String(secret),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceExistsException {
+		_, err = p.put(name, secret)
+	}
+	if err != nil {
+		redact.Println(err)
+		return "", errors.New(ErrorCouldNotStoreSecret)
+	}
+
+	return name, nil
+}
+
+func (p *SecretsManagerProvider) Resolve(ref string) (string, string, error) {
+	name, version := splitSecretsManagerRef(ref)
+
+	input := &secretsmanager.GetSecretValueInput{SecretId: aws.String(name)}
+	if len(version) > 0 {
+		input.VersionId = aws.String(version)
+	}
+
+	output, err := p.client.GetSecretValue(input)
+	if err != nil {
+		redact.Println(err)
+		return "", "", errors.New(ErrorCouldNotResolveSecret)
+	}
+
+	return aws.StringValue(output.SecretString), aws.StringValue(output.VersionId), nil
+}
+
+func (p *SecretsManagerProvider) Stage(ref string, secret string) (string, error) {
+	name, _ := splitSecretsManagerRef(ref)
+
+	version, err := p.put(name, secret, stagePending)
+	if err != nil {
+		redact.Println(err)
+		return "", errors.New(ErrorCouldNotStoreSecret)
+	}
+
+	return name + "#" + version, nil
+}
+
+func (p *SecretsManagerProvider) Promote(ref string, staged string) (string, error) {
+	name, version := splitSecretsManagerRef(staged)
+
+	secret, err := p.client.DescribeSecret(&secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	if err != nil {
+		redact.Println(err)
+		return "", errors.New(ErrorCouldNotStoreSecret)
+	}
+
+	input := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:        aws.String(name),
+		VersionStage:    aws.String(stageCurrent),
+		MoveToVersionId: aws.String(version),
+	}
+	for id, stages := range secret.VersionIdsToStages {
+		for _, stage := range stages {
+			if aws.StringValue(stage) == stageCurrent && id != version {
+				input.RemoveFromVersionId = aws.String(id)
+			}
+		}
+	}
+	if _, err := p.client.UpdateSecretVersionStage(input); err != nil {
+		redact.Println(err)
+		return "", errors.New(ErrorCouldNotStoreSecret)
+	}
+	p.Discard(staged)
+
+	return name + "#" + version, nil
+}
+
+// Discard takes AWSPENDING off the staged version. Versions left without
+// a stage are removed by Secrets Manager.
+func (p *SecretsManagerProvider) Discard(staged string) error {
+	name, version := splitSecretsManagerRef(staged)
+
+	_, err := p.client.UpdateSecretVersionStage(&secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            aws.String(name),
+		VersionStage:        aws.String(stagePending),
+		RemoveFromVersionId: aws.String(version),
+	})
+	if err != nil {
+		redact.Println(err)
+		return errors.New(ErrorCouldNotDeleteSecret)
+	}
+
+	return nil
+}
+
+func (p *SecretsManagerProvider) put(name string, secret string, stages ...string) (string, error) {
+	input := &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(name),
+		SecretString: aws.String(secret),
+	}
+	if len(stages) > 0 {
+		input.VersionStages = aws.StringSlice(stages)
+	}
+
+	output, err := p.client.PutSecretValue(input)
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(output.VersionId), nil
+}
+
+func (p *SecretsManagerProvider) Delete(ref string) error {
+	name, _ := splitSecretsManagerRef(ref)
+
+	_, err := p.client.DeleteSecret(&secretsmanager.DeleteSecretInput{
+		SecretId:                   aws.String(name),
+		ForceDeleteWithoutRecovery: aws.Bool(true),
+	})
+	if err != nil {
+		redact.Println(err)
+		return errors.New(ErrorCouldNotDeleteSecret)
+	}
+
+	return nil
+}
+
+func splitSecretsManagerRef(ref string) (string, string) {
+	if i := strings.LastIndex(ref, "#"); i > 0 {
+		return ref[:i], ref[i+1:]
+	}
+
+	return ref, ""
+}
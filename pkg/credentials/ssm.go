@@ -0,0 +1,126 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"hermes/pkg/common/redact"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// ssmPendingSuffix names the parameter a rotated secret is staged under.
+const ssmPendingSuffix = "/pending"
+
+// SSMProvider stores secrets as SecureString parameters named after
+// SecretName. References may pin a version with the native "name:version"
+// selector. Rotated secrets are staged under a parameter of their own, as a
+// new version of the parameter would be read by every unpinned reference.
+type SSMProvider struct {
+	ssmClient ssmiface.SSMAPI
+}
+
+func NewSSMProvider(ssmClient ssmiface.SSMAPI) *SSMProvider {
+	return &SSMProvider{ssmClient: ssmClient}
+}
+
+func (p *SSMProvider) Store(datasetId string, secret string) (string, error) {
+	name := SecretName(datasetId)
+	_, err := p.put(name, secret)
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+func (p *SSMProvider) Resolve(ref string) (string, string, error) {
+	output, err := p.ssmClient.GetParameter(&ssm.GetParameterInput{Name: aws.String(ref), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		redact.Println(err)
+		return "", "", errors.New(ErrorCouldNotResolveSecret)
+	}
+
+	return aws.StringValue(output.Parameter.Value), strconv.FormatInt(aws.Int64Value(output.Parameter.Version), 10), nil
+}
+
+func (p *SSMProvider) Stage(ref string, secret string) (string, error) {
+	staged := ssmTargetName(ref) + ssmPendingSuffix
+	if _, err := p.put(staged, secret); err != nil {
+		return "", err
+	}
+
+	return staged, nil
+}
+
+func (p *SSMProvider) Promote(ref string, staged string) (string, error) {
+	secret, _, err := p.Resolve(staged)
+	if err != nil {
+		return "", err
+	}
+
+	name := ssmTargetName(ref)
+	version, err := p.put(name, secret)
+	if err != nil {
+		return "", err
+	}
+	p.Discard(staged)
+
+	return fmt.Sprintf("%s:%d", name, version), nil
+}
+
+func (p *SSMProvider) Discard(staged string) error {
+	return p.Delete(staged)
+}
+
+func (p *SSMProvider) Delete(ref string) error {
+	_, err := p.ssmClient.DeleteParameter(&ssm.DeleteParameterInput{Name: aws.String(ssmParameterName(ref))})
+	if err != nil {
+		redact.Println(err)
+		return errors.New(ErrorCouldNotDeleteSecret)
+	}
+
+	return nil
+}
+
+func (p *SSMProvider) put(name string, secret string) (int64, error) {
+	output, err := p.ssmClient.PutParameter(&ssm.PutParameterInput{
+		DataType:  aws.String("text"),
+		Name:      aws.String(name),
+		Value:     aws.String(secret),
+		Type:      aws.String("SecureString"),
+		Overwrite: aws.Bool(true),
+	})
+	if err != nil {
+		redact.Println(err)
+		return 0, errors.New(ErrorCouldNotStoreSecret)
+	}
+
+	return aws.Int64Value(output.Version), nil
+}
+
+// ssmParameterName strips a ":version" selector from ref.
+func ssmParameterName(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i > 0 {
+		if _, err := strconv.Atoi(ref[i+1:]); err == nil {
+			return ref[:i]
+		}
+	}
+
+	return ref
+}
+
+// ssmTargetName returns the parameter a rotation of ref writes to. Secrets
+// stored before namespacing are referenced by the bare dataset id, which
+// SSM can't nest a pending parameter under, so they move to SecretName.
+func ssmTargetName(ref string) string {
+	name := ssmParameterName(ref)
+	if !strings.HasPrefix(name, "/") {
+		return SecretName(name)
+	}
+
+	return name
+}
@@ -1,57 +1,110 @@
 package datasets
 
 import (
-	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"hermes/pkg/common/redact"
+	"hermes/pkg/credentials"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ssm"
-	_ "github.com/lib/pq"
 )
 
 type Connection struct {
-	Credentials string `json:"credentials"`
-	Type        string `json:"type"`
-	Provider    string `json:"provider"`
+	Credentials string           `json:"credentials"`
+	Type        string           `json:"type"`
+	Provider    string           `json:"provider"`
+	HTTP        *HTTPOptions     `json:"http,omitempty"`
+	DynamoDB    *DynamoDBOptions `json:"dynamodb,omitempty"`
+	SSH         *SSHTunnel       `json:"ssh,omitempty"`
+	TLS         *TLSOptions      `json:"tls,omitempty"`
 }
 
 var (
-	ErrorInvalidConnectionData             = "invalid connection data"
-	ErrorInvalidConnectionCredentials      = "invalid connection credentials"
-	ErrorUnableToPing                      = "unable to ping connect. check credentials and access"
-	ErrorCouldNotSecureRetrieveCredentials = "could not retrieve securely credentials from SSM"
+	ErrorInvalidConnectionData        = "invalid connection data"
+	ErrorInvalidConnectionCredentials = "invalid connection credentials"
+	ErrorUnableToPing                 = "unable to ping connect. check credentials and access"
 )
 
-func EnsureConnection(req events.APIGatewayProxyRequest, ssmClient *ssm.SSM) error {
+func EnsureConnection(req events.APIGatewayProxyRequest) error {
 	var c Connection
 	if err := json.Unmarshal([]byte(req.Body), &c); err != nil {
 		return errors.New(ErrorInvalidConnectionData)
 	}
-	parsedCrendentials := c.Credentials
 
-	if c.Provider == "ssm" {
-		credentials, err := ssmClient.GetParameter(&ssm.GetParameterInput{Name: aws.String(c.Credentials), WithDecryption: aws.Bool(true)})
-		if err != nil {
-			return errors.New(ErrorCouldNotSecureRetrieveCredentials)
-		}
-		parsedCrendentials = *credentials.Parameter.Value
+	return testConnection(c)
+}
+
+func testConnection(c Connection) error {
+	parsedCrendentials, _, err := resolveCredentials(c)
+	if err != nil {
+		return err
 	}
 
-	db, err := sql.Open("postgres", parsedCrendentials)
+	source, err := OpenSource(c, parsedCrendentials)
 	if err != nil {
-		return errors.New(ErrorInvalidConnectionCredentials)
+		return err
 	}
-	defer db.Close()
+	defer source.Close()
 
-	err = db.Ping()
+	err = source.Ping()
 
 	if err != nil {
-		fmt.Println(err)
+		redact.Println(err)
+		if info := presentedChain(c, parsedCrendentials, err); info != nil {
+			return &TLSError{Err: errors.New(ErrorUnableToPing), Info: info}
+		}
 		return errors.New(ErrorUnableToPing)
 	}
 
 	return nil
 }
+
+// presentedChain returns the certificates the server presented when a
+// connection over TLS failed. When the failure came from elsewhere, the
+// server is asked for them again so the caller can tell whether TLS is
+// part of the problem.
+func presentedChain(c Connection, secret string, err error) *TLSInfo {
+	var tlsErr *TLSError
+	if errors.As(err, &tlsErr) {
+		return tlsErr.Info
+	}
+
+	e, ok := connectionEndpoint(c, secret)
+	if !ok || c.SSH != nil || (!e.tls && !e.startTLS) {
+		return nil
+	}
+
+	probe := &Diagnostics{}
+	diagnoseNetwork(probe, e)
+
+	return probe.TLSInfo
+}
+
+// OpenDataset returns a source for a stored dataset from the shared
+// connection manager, so warm invocations reuse the same pool.
+func OpenDataset(d *DataSet) (Source, error) {
+	c := d.Connection()
+	secret, version, err := resolveCredentials(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return Connections.Acquire(d.Id, version, c, secret)
+}
+
+// Fingerprint changes whenever the connection options or the credential
+// reference of the dataset change.
+func (d *DataSet) Fingerprint() string {
+	return connectionFingerprint("", d.Connection())
+}
+
+// resolveCredentials returns the secret behind the connection credentials
+// and the version of that secret, when its provider keeps one.
+func resolveCredentials(c Connection) (string, string, error) {
+	provider, err := credentials.Get(c.Provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	return provider.Resolve(c.Credentials)
+}
@@ -0,0 +1,335 @@
+package datasets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/common/redact"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+var (
+	DatasetBucket             = os.Getenv("DATASET_BUCKET")
+	DefaultCSVMaxRows         = 100000
+	ErrorInvalidCSVEncoding   = "csv file must be UTF-8 encoded"
+	ErrorInvalidCSVFile       = "could not parse csv file"
+	ErrorInvalidCSVHeader     = "csv header must have unique, non-empty column names"
+	ErrorCSVTooManyRows       = "csv file has more rows than allowed"
+	ErrorCSVOnlyNoneProvider  = "csv datasets only support the none provider"
+	ErrorDatasetIsNotCSV      = "dataset is not of type csv"
+	ErrorCouldNotStoreCSV     = "could not store csv file in S3"
+	ErrorCouldNotRetrieveCSV  = "could not retrieve csv file from S3"
+	ErrorInvalidCSVLocation   = "invalid csv location. Expected s3://bucket/key"
+	ErrorMissingDatasetBucket = "DATASET_BUCKET is not configured"
+)
+
+const (
+	ColumnTypeString  = "string"
+	ColumnTypeInteger = "integer"
+	ColumnTypeNumber  = "number"
+	ColumnTypeBoolean = "boolean"
+	ColumnTypeDate    = "date"
+)
+
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type csvDriver struct {
+	s3Client s3iface.S3API
+}
+
+type csvSource struct {
+	s3Client s3iface.S3API
+	bucket   string
+	key      string
+}
+
+// NewCSVDriver returns the driver for "csv" datasets, whose credentials are
+// the s3://bucket/key location written by UploadCSV.
+func NewCSVDriver(s3Client s3iface.S3API) Driver {
+	return csvDriver{s3Client: s3Client}
+}
+
+func (d csvDriver) Open(c Connection, r Resolved) (Source, error) {
+	bucket, key, err := parseS3Location(r.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csvSource{s3Client: d.s3Client, bucket: bucket, key: key}, nil
+}
+
+func (s *csvSource) Ping() error {
+	_, err := s.s3Client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key)})
+	return err
+}
+
+func (s *csvSource) Query(query string, args ...interface{}) ([]Row, error) {
+	object, err := s.s3Client.GetObject(&s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key)})
+	if err != nil {
+		redact.Println(err)
+		return nil, errors.New(ErrorCouldNotRetrieveCSV)
+	}
+	defer object.Body.Close()
+
+	data, err := ioutil.ReadAll(object.Body)
+	if err != nil {
+		return nil, errors.New(ErrorCouldNotRetrieveCSV)
+	}
+
+	columns, rows, err := ParseCSV(data, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := parseCSVQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.apply(columns, rows, args)
+}
+
+func (s *csvSource) Close() error {
+	return nil
+}
+
+// UploadCSV validates the request body as a CSV file, stores it in the
+// dataset bucket and records the inferred columns on the dataset.
+func UploadCSV(req events.APIGatewayProxyRequest, repo crud.CrudRepository, s3Client s3iface.S3API, id string) (
+	*DataSet,
+	error,
+) {
+	d, _ := FetchDataset(id, repo)
+	if d != nil && len(d.Name) == 0 {
+		return nil, errors.New(ErrorDatasetDoesNotExists)
+	}
+
+	if d.Type != "csv" {
+		return nil, errors.New(ErrorDatasetIsNotCSV)
+	}
+
+	if len(DatasetBucket) == 0 {
+		return nil, errors.New(ErrorMissingDatasetBucket)
+	}
+
+	data := []byte(req.Body)
+	if req.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(req.Body)
+		if err != nil {
+			return nil, errors.New(ErrorInvalidCSVFile)
+		}
+		data = decoded
+	}
+
+	columns, rows, err := ParseCSV(data, csvMaxRows())
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Recipients != nil {
+		if err := d.Recipients.CheckColumns(columnNames(columns)); err != nil {
+			return nil, err
+		}
+	}
+
+	key := fmt.Sprintf("datasets/%s.csv", d.Id)
+	_, err = s3Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(DatasetBucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("text/csv"),
+	})
+	if err != nil {
+		redact.Println(err)
+		return nil, errors.New(ErrorCouldNotStoreCSV)
+	}
+
+	d.Credentials = fmt.Sprintf("s3://%s/%s", DatasetBucket, key)
+	d.Columns = columns
+	d.RowCount = len(rows)
+
+	_, err = repo.Update(d.Id, d)
+	if err != nil {
+		return nil, err
+	}
+	Connections.Evict(d.Id)
+
+	return d, nil
+}
+
+func deleteCSV(d *DataSet, s3Client s3iface.S3API) {
+	bucket, key, err := parseS3Location(d.Credentials)
+	if err != nil {
+		return
+	}
+
+	s3Client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+}
+
+// ParseCSV reads a CSV file with a header line and returns its columns with
+// inferred types and the rows converted to those types. A maxRows of zero
+// disables the row limit.
+func ParseCSV(data []byte, maxRows int) ([]Column, []Row, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		return nil, nil, errors.New(ErrorInvalidCSVEncoding)
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, errors.New(ErrorInvalidCSVFile)
+	}
+
+	seen := map[string]bool{}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 || seen[name] {
+			return nil, nil, errors.New(ErrorInvalidCSVHeader)
+		}
+		seen[name] = true
+		header[i] = name
+	}
+
+	records := [][]string{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", ErrorInvalidCSVFile, err)
+		}
+
+		records = append(records, record)
+		if maxRows > 0 && len(records) > maxRows {
+			return nil, nil, fmt.Errorf("%s (%d)", ErrorCSVTooManyRows, maxRows)
+		}
+	}
+
+	columns := make([]Column, len(header))
+	for i, name := range header {
+		columns[i] = Column{Name: name, Type: inferColumnType(records, i)}
+	}
+
+	rows := make([]Row, len(records))
+	for r, record := range records {
+		row := Row{}
+		for i, column := range columns {
+			row[column.Name] = convertCSVValue(record[i], column.Type)
+		}
+		rows[r] = row
+	}
+
+	return columns, rows, nil
+}
+
+func inferColumnType(records [][]string, index int) string {
+	candidates := []string{ColumnTypeInteger, ColumnTypeNumber, ColumnTypeBoolean, ColumnTypeDate}
+
+	found := false
+	for _, record := range records {
+		value := strings.TrimSpace(record[index])
+		if len(value) == 0 {
+			continue
+		}
+		found = true
+
+		remaining := candidates[:0]
+		for _, t := range candidates {
+			if convertCSVValue(value, t) != nil {
+				remaining = append(remaining, t)
+			}
+		}
+		candidates = remaining
+
+		if len(candidates) == 0 {
+			return ColumnTypeString
+		}
+	}
+
+	if !found {
+		return ColumnTypeString
+	}
+
+	return candidates[0]
+}
+
+// convertCSVValue returns nil for empty values and for values that do not
+// parse as columnType.
+func convertCSVValue(value string, columnType string) interface{} {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return nil
+	}
+
+	switch columnType {
+	case ColumnTypeInteger:
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return v
+		}
+	case ColumnTypeNumber:
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			return v
+		}
+	case ColumnTypeBoolean:
+		if v, err := strconv.ParseBool(value); err == nil {
+			return v
+		}
+	case ColumnTypeDate:
+		if v, ok := parseDate(value); ok {
+			return v
+		}
+	default:
+		return value
+	}
+
+	return nil
+}
+
+func parseDate(value string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func parseS3Location(location string) (string, string, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "s3" || len(u.Host) == 0 || len(u.Path) <= 1 {
+		return "", "", errors.New(ErrorInvalidCSVLocation)
+	}
+
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+func csvMaxRows() int {
+	if v, err := strconv.Atoi(os.Getenv("CSV_MAX_ROWS")); err == nil && v > 0 {
+		return v
+	}
+
+	return DefaultCSVMaxRows
+}
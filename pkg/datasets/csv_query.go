@@ -0,0 +1,365 @@
+package datasets
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	ErrorInvalidCSVQuery  = "invalid csv query"
+	ErrorUnknownCSVColumn = "csv query references an unknown column"
+)
+
+// csvQuery is the small query language used by Notification.Query against
+// csv datasets:
+//
+//	[SELECT * | col, ...] [WHERE col op value [AND ...]] [LIMIT n]
+//
+// op is one of = != <> > >= < <=, value is a 'string', a number, true/false,
+// null or a $n placeholder bound to the query arguments. Column names that
+// contain spaces can be written between double quotes.
+type csvQuery struct {
+	columns    []string
+	conditions []csvCondition
+	limit      int
+}
+
+type csvCondition struct {
+	column      string
+	op          string
+	value       interface{}
+	placeholder int
+}
+
+type csvToken struct {
+	kind  string
+	value string
+}
+
+func parseCSVQuery(query string) (*csvQuery, error) {
+	tokens, err := tokenizeCSVQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &csvQuery{}
+	pos := 0
+	next := func() csvToken {
+		if pos >= len(tokens) {
+			return csvToken{kind: "eof"}
+		}
+		t := tokens[pos]
+		pos++
+		return t
+	}
+	peekKeyword := func(keyword string) bool {
+		return pos < len(tokens) && tokens[pos].kind == "ident" && strings.EqualFold(tokens[pos].value, keyword)
+	}
+
+	if peekKeyword("select") {
+		pos++
+		for {
+			t := next()
+			if t.kind == "*" {
+				q.columns = nil
+			} else if t.kind == "ident" || t.kind == "quoted" {
+				q.columns = append(q.columns, t.value)
+			} else {
+				return nil, invalidCSVQuery("expected column name")
+			}
+
+			if pos < len(tokens) && tokens[pos].kind == "," {
+				pos++
+				continue
+			}
+			break
+		}
+	}
+
+	if peekKeyword("where") {
+		pos++
+		for {
+			column := next()
+			if column.kind != "ident" && column.kind != "quoted" {
+				return nil, invalidCSVQuery("expected column name")
+			}
+
+			op := next()
+			if op.kind != "op" {
+				return nil, invalidCSVQuery("expected comparison operator")
+			}
+
+			cond := csvCondition{column: column.value, op: op.value}
+			value := next()
+			switch value.kind {
+			case "string":
+				cond.value = value.value
+			case "number":
+				cond.value, _ = strconv.ParseFloat(value.value, 64)
+			case "placeholder":
+				cond.placeholder, _ = strconv.Atoi(value.value)
+			case "ident":
+				switch strings.ToLower(value.value) {
+				case "true":
+					cond.value = true
+				case "false":
+					cond.value = false
+				case "null":
+					cond.value = nil
+				default:
+					return nil, invalidCSVQuery("expected value")
+				}
+			default:
+				return nil, invalidCSVQuery("expected value")
+			}
+			q.conditions = append(q.conditions, cond)
+
+			if peekKeyword("and") {
+				pos++
+				continue
+			}
+			break
+		}
+	}
+
+	if peekKeyword("limit") {
+		pos++
+		t := next()
+		limit, err := strconv.Atoi(t.value)
+		if t.kind != "number" || err != nil || limit < 0 {
+			return nil, invalidCSVQuery("expected limit")
+		}
+		q.limit = limit
+	}
+
+	if pos != len(tokens) {
+		return nil, invalidCSVQuery(fmt.Sprintf("unexpected %q", tokens[pos].value))
+	}
+
+	return q, nil
+}
+
+func tokenizeCSVQuery(query string) ([]csvToken, error) {
+	tokens := []csvToken{}
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '*' || r == ',':
+			tokens = append(tokens, csvToken{kind: string(r), value: string(r)})
+			i++
+		case r == '\'' || r == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(runes); j++ {
+				if runes[j] == r {
+					// a doubled quote is an escaped quote
+					if j+1 < len(runes) && runes[j+1] == r {
+						sb.WriteRune(r)
+						j++
+						continue
+					}
+					break
+				}
+				sb.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, invalidCSVQuery("unterminated quote")
+			}
+			kind := "string"
+			if r == '"' {
+				kind = "quoted"
+			}
+			tokens = append(tokens, csvToken{kind: kind, value: sb.String()})
+			i = j + 1
+		case r == '$':
+			j := i + 1
+			for j < len(runes) && unicode.IsDigit(runes[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, invalidCSVQuery("expected placeholder number")
+			}
+			tokens = append(tokens, csvToken{kind: "placeholder", value: string(runes[i+1 : j])})
+			i = j
+		case strings.ContainsRune("=!<>", r):
+			j := i + 1
+			if j < len(runes) && strings.ContainsRune("=>", runes[j]) {
+				j++
+			}
+			op := string(runes[i:j])
+			if op == "<>" {
+				op = "!="
+			}
+			switch op {
+			case "=", "!=", ">", ">=", "<", "<=":
+			default:
+				return nil, invalidCSVQuery(fmt.Sprintf("unknown operator %q", op))
+			}
+			tokens = append(tokens, csvToken{kind: "op", value: op})
+			i = j
+		case unicode.IsDigit(r) || r == '-' || r == '.':
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, csvToken{kind: "number", value: string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, csvToken{kind: "ident", value: string(runes[i:j])})
+			i = j
+		default:
+			return nil, invalidCSVQuery(fmt.Sprintf("unexpected %q", r))
+		}
+	}
+
+	return tokens, nil
+}
+
+func (q *csvQuery) apply(columns []Column, rows []Row, args []interface{}) ([]Row, error) {
+	known := map[string]bool{}
+	for _, c := range columns {
+		known[c.Name] = true
+	}
+
+	for _, name := range q.columns {
+		if !known[name] {
+			return nil, fmt.Errorf("%s: %s", ErrorUnknownCSVColumn, name)
+		}
+	}
+
+	conditions := make([]csvCondition, len(q.conditions))
+	for i, cond := range q.conditions {
+		if !known[cond.column] {
+			return nil, fmt.Errorf("%s: %s", ErrorUnknownCSVColumn, cond.column)
+		}
+		if cond.placeholder > 0 {
+			if cond.placeholder > len(args) {
+				return nil, invalidCSVQuery(fmt.Sprintf("missing argument $%d", cond.placeholder))
+			}
+			cond.value = args[cond.placeholder-1]
+		}
+		conditions[i] = cond
+	}
+
+	result := []Row{}
+	for _, row := range rows {
+		matches := true
+		for _, cond := range conditions {
+			if !compareCSVValue(row[cond.column], cond.op, cond.value) {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		if len(q.columns) > 0 {
+			projected := Row{}
+			for _, name := range q.columns {
+				projected[name] = row[name]
+			}
+			row = projected
+		}
+		result = append(result, row)
+
+		if q.limit > 0 && len(result) >= q.limit {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// compareCSVValue coerces expected to the type of the row value before
+// comparing them with op.
+func compareCSVValue(actual interface{}, op string, expected interface{}) bool {
+	if actual == nil || expected == nil {
+		equal := actual == nil && expected == nil
+		if op == "=" {
+			return equal
+		}
+		return op == "!=" && !equal
+	}
+
+	cmp := 0
+	switch a := actual.(type) {
+	case int64, float64:
+		af, _ := strconv.ParseFloat(fmt.Sprint(a), 64)
+		ef, err := strconv.ParseFloat(fmt.Sprint(expected), 64)
+		if err != nil {
+			return op == "!="
+		}
+		cmp = compareFloats(af, ef)
+	case bool:
+		eb, err := strconv.ParseBool(fmt.Sprint(expected))
+		if err != nil {
+			return op == "!="
+		}
+		if a == eb {
+			cmp = 0
+		} else if !a {
+			cmp = -1
+		} else {
+			cmp = 1
+		}
+	case time.Time:
+		et, ok := expected.(time.Time)
+		if !ok {
+			et, ok = parseDate(fmt.Sprint(expected))
+		}
+		if !ok {
+			return op == "!="
+		}
+		if a.Before(et) {
+			cmp = -1
+		} else if a.After(et) {
+			cmp = 1
+		}
+	default:
+		cmp = strings.Compare(fmt.Sprint(a), fmt.Sprint(expected))
+	}
+
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+
+	return false
+}
+
+func compareFloats(a, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func invalidCSVQuery(reason string) error {
+	return errors.New(ErrorInvalidCSVQuery + ": " + reason)
+}
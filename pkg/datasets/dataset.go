@@ -3,33 +3,83 @@ package datasets
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"hermes/pkg/common/crud"
 	BaseErrors "hermes/pkg/common/errors"
+	"hermes/pkg/common/redact"
+	"hermes/pkg/credentials"
+	"net/url"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
 var (
-	TableName                           = os.Getenv("TABLE_NAME")
-	ErrorInvalidDatasetData             = "invalid dataset data"
-	ErrorInvalidProvider                = "invalid provider"
-	ErrorInvalidType                    = "invalid type. Only SQL is supported"
-	ErrorDatasetAlreadyExists           = "dataset.Dataset already exists"
-	ErrorDatasetDoesNotExists           = "dataset.Dataset does not exist"
-	ErrorCouldNotSecureStoreCredentials = "could not store your credentials securely in SSM"
+	TableName                 = os.Getenv("TABLE_NAME")
+	ErrorInvalidDatasetData   = "invalid dataset data"
+	ErrorInvalidProvider      = "invalid provider"
+	ErrorInvalidType          = "invalid type. Only sql, csv, http and dynamodb are supported"
+	ErrorDatasetAlreadyExists = "dataset.Dataset already exists"
+	ErrorDatasetDoesNotExists = "dataset.Dataset does not exist"
+	ErrorMaskedSecret         = "credentials were sent back masked. Send the secret itself to change them"
 )
 
 type DataSet struct {
-	Id          string   `json:"id"`
-	Name        string   `json:"name"`
-	Credentials string   `json:"credentials"`
-	Type        string   `json:"type"`
-	Provider    string   `json:"provider"`
-	Tags        []string `json:"tags"`
+	Id          string            `json:"id"`
+	Name        string            `json:"name"`
+	Credentials string            `json:"credentials"`
+	Type        string            `json:"type"`
+	Provider    string            `json:"provider"`
+	Tags        []string          `json:"tags"`
+	Columns     []Column          `json:"columns,omitempty"`
+	RowCount    int               `json:"rowCount,omitempty"`
+	HTTP        *HTTPOptions      `json:"http,omitempty"`
+	DynamoDB    *DynamoDBOptions  `json:"dynamodb,omitempty"`
+	SSH         *SSHTunnel        `json:"ssh,omitempty"`
+	TLS         *TLSOptions       `json:"tls,omitempty"`
+	Recipients  *RecipientMapping `json:"recipients,omitempty"`
+	Health      *HealthStatus     `json:"health,omitempty"`
+}
+
+func (d DataSet) Connection() Connection {
+	return Connection{Credentials: d.Credentials, Type: d.Type, Provider: d.Provider, HTTP: d.HTTP, DynamoDB: d.DynamoDB, SSH: d.SSH, TLS: d.TLS}
+}
+
+// Redacted returns a copy of the dataset that is safe to return or log.
+// Stored references are kept, while inline secrets are masked: DSNs keep
+// everything but their password, other values are masked whole. The S3
+// location of a csv dataset holds no secret.
+func (d DataSet) Redacted() DataSet {
+	if d.Provider == "none" && d.Type != "csv" && len(d.Credentials) > 0 {
+		if u, err := url.Parse(d.Credentials); err == nil && len(u.Scheme) > 0 && u.User != nil {
+			d.Credentials = redact.URL(d.Credentials)
+		} else {
+			d.Credentials = redact.Mask
+		}
+	}
+
+	if d.HTTP != nil {
+		options := *d.HTTP
+		options.URL = redact.URL(options.URL)
+		options.Headers = redact.Headers(options.Headers)
+		options.Body = redact.String(options.Body)
+		d.HTTP = &options
+	}
+
+	if d.SSH != nil && d.SSH.keyProvider(d.Provider) == "none" {
+		tunnel := *d.SSH
+		tunnel.PrivateKey = redact.Mask
+		d.SSH = &tunnel
+	}
+
+	if d.TLS != nil && len(d.TLS.ClientKey) > 0 && d.TLS.keyProvider(d.Provider) == "none" {
+		options := *d.TLS
+		options.ClientKey = redact.Mask
+		d.TLS = &options
+	}
+
+	return d
 }
 
 func FetchDataset(id string, repo crud.CrudRepository) (*DataSet, error) {
@@ -41,12 +91,12 @@ func FetchDataset(id string, repo crud.CrudRepository) (*DataSet, error) {
 
 func FetchDatasets(repo crud.CrudRepository) (*[]DataSet, error) {
 	item := new([]DataSet)
-	_, err := repo.Create(item)
+	_, err := repo.List(item)
 
 	return item, err
 }
 
-func CreateDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository, ssmClient *ssm.SSM) (
+func CreateDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (
 	*DataSet,
 	error,
 ) {
@@ -55,6 +105,10 @@ func CreateDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository,
 		return nil, errors.New(ErrorInvalidDatasetData)
 	}
 
+	if err := ValidateDatasetId(d.Id); err != nil {
+		return nil, err
+	}
+
 	if !IsProviderValid(d.Provider) {
 		return nil, errors.New(ErrorInvalidProvider)
 	}
@@ -63,17 +117,27 @@ func CreateDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository,
 		return nil, errors.New(ErrorInvalidType)
 	}
 
-	if d.Provider == "ssm" {
-		_, err := ssmClient.PutParameter(&ssm.PutParameterInput{DataType: aws.String("text"), Name: aws.String(d.Id), Value: aws.String(d.Credentials), Type: aws.String("SecureString")})
-		if err != nil {
-			fmt.Println(err)
-			return nil, errors.New(ErrorCouldNotSecureStoreCredentials)
-		}
-		d.Credentials = d.Id
+	if d.Type == "csv" && d.Provider != "none" {
+		return nil, errors.New(ErrorCSVOnlyNoneProvider)
 	}
-	// Save dataset
 
-	_, err := repo.Create(d)
+	if err := ValidateOptions(&d); err != nil {
+		return nil, err
+	}
+
+	provider, _ := credentials.Get(d.Provider)
+	ref, err := provider.Store(d.Id, d.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	d.Credentials = ref
+
+	if err := storeAttachedSecrets(&d); err != nil {
+		return nil, err
+	}
+
+	// Save dataset
+	_, err = repo.Create(d)
 
 	if err != nil {
 		return nil, err
@@ -81,7 +145,7 @@ func CreateDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository,
 	return &d, nil
 }
 
-func UpdateDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository, ssmClient *ssm.SSM) (
+func UpdateDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (
 	*DataSet,
 	error,
 ) {
@@ -104,11 +168,66 @@ func UpdateDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository,
 		return nil, errors.New(ErrorInvalidType)
 	}
 
-	if currentDataset.Credentials != d.Credentials && d.Provider == "ssm" {
-		_, err := ssmClient.PutParameter(&ssm.PutParameterInput{DataType: aws.String("text"), Name: aws.String(d.Id), Value: aws.String(d.Credentials), Type: aws.String("SecureString"), Overwrite: aws.Bool(true)})
+	if d.Type == "csv" {
+		if d.Provider != "none" {
+			return nil, errors.New(ErrorCSVOnlyNoneProvider)
+		}
+		// the file, its location and its schema only change through an upload
+		d.Credentials = currentDataset.Credentials
+		d.Columns = currentDataset.Columns
+		d.RowCount = currentDataset.RowCount
+	}
+
+	if err := ValidateOptions(&d); err != nil {
+		return nil, err
+	}
+
+	// health is only written by the scheduled monitor
+	d.Health = currentDataset.Health
+
+	// Credentials only carry a new secret when they differ from the stored
+	// reference. Leaving them out keeps the stored secret; use the
+	// rotate-credentials endpoint to change it safely.
+	if len(d.Credentials) == 0 && currentDataset.Provider == d.Provider {
+		d.Credentials = currentDataset.Credentials
+	}
+
+	// Inline credentials are returned masked; sending them back unchanged
+	// keeps the stored ones, but a masked value can't carry other changes.
+	if strings.Contains(d.Credentials, redact.Mask) {
+		if currentDataset.Provider != d.Provider || d.Credentials != currentDataset.Redacted().Credentials {
+			return nil, errors.New(ErrorMaskedSecret)
+		}
+		d.Credentials = currentDataset.Credentials
+	}
+
+	if err := restoreMaskedHTTP(&d, currentDataset); err != nil {
+		return nil, err
+	}
+
+	if currentDataset.Credentials != d.Credentials || currentDataset.Provider != d.Provider {
+		provider, _ := credentials.Get(d.Provider)
+		ref, err := provider.Store(d.Id, d.Credentials)
 		if err != nil {
-			return nil, errors.New(ErrorCouldNotSecureStoreCredentials)
+			return nil, err
+		}
+		d.Credentials = ref
+
+		// datasets created before namespacing kept their secret under the
+		// bare id, which the new one replaces
+		if currentDataset.Provider == d.Provider && currentDataset.Credentials == d.Id && ref != d.Id {
+			provider.Delete(currentDataset.Credentials)
 		}
+
+		if currentDataset.Provider != d.Provider {
+			if previous, err := credentials.Get(currentDataset.Provider); err == nil {
+				previous.Delete(currentDataset.Credentials)
+			}
+		}
+	}
+
+	if err := updateAttachedSecrets(&d, currentDataset); err != nil {
+		return nil, err
 	}
 
 	// Save dataset
@@ -118,10 +237,12 @@ func UpdateDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository,
 		return nil, err
 	}
 
+	Connections.Evict(d.Id)
+
 	return &d, nil
 }
 
-func DeleteDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository, ssmClient *ssm.SSM) error {
+func DeleteDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository, s3Client s3iface.S3API) error {
 	id := req.PathParameters["id"]
 
 	currentDataset, _ := FetchDataset(id, repo)
@@ -129,15 +250,23 @@ func DeleteDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository,
 		return nil
 	}
 
-	if currentDataset.Provider == "ssm" {
-		ssmClient.DeleteParameter(&ssm.DeleteParameterInput{Name: aws.String(id)})
+	if provider, err := credentials.Get(currentDataset.Provider); err == nil {
+		provider.Delete(currentDataset.Credentials)
+	}
+
+	deleteAttachedSecrets(currentDataset)
+
+	if currentDataset.Type == "csv" {
+		deleteCSV(currentDataset, s3Client)
 	}
 
 	err := repo.Delete(id)
 	if err != nil {
-		fmt.Println(err)
+		redact.Println(err)
 		return errors.New(BaseErrors.ErrorCouldNotDeleteItem)
 	}
 
+	Connections.Evict(id)
+
 	return nil
 }
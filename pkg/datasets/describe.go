@@ -0,0 +1,113 @@
+package datasets
+
+import (
+	"errors"
+	"hermes/pkg/common/redact"
+	"strings"
+)
+
+var (
+	ErrorCouldNotDescribeQuery = "could not tell the columns of the query"
+)
+
+// Describer is implemented by sources that can tell the columns a query
+// returns without fetching its rows.
+type Describer interface {
+	Describe(query string, args []interface{}) ([]string, error)
+}
+
+// QueryColumns returns the columns query returns from the dataset. csv
+// datasets tell them from their stored schema and sql ones from the
+// database; for other types, csv datasets without an upload yet and sql
+// datasets that can't be reached or fail to describe the query, it logs
+// why and returns false.
+func QueryColumns(d *DataSet, query string, args []interface{}) ([]string, bool, error) {
+	switch d.Type {
+	case "csv":
+		if len(d.Columns) == 0 {
+			return nil, false, nil
+		}
+		columns, err := csvQueryColumns(d, query)
+		if err != nil {
+			return nil, false, err
+		}
+		return columns, true, nil
+	case "sql":
+	default:
+		return nil, false, nil
+	}
+
+	source, err := OpenDataset(d)
+	if err != nil {
+		redact.Println("could not open dataset", d.Id, "to describe a query:", err)
+		return nil, false, nil
+	}
+	defer source.Close()
+
+	describer, ok := source.(Describer)
+	if !ok {
+		return nil, false, nil
+	}
+
+	columns, err := describer.Describe(query, args)
+	if err != nil {
+		redact.Println("could not describe a query of dataset", d.Id, "so its columns are not checked:", err)
+		return nil, false, nil
+	}
+
+	return columns, true, nil
+}
+
+// csvQueryColumns returns the columns a csv query selects, all of them for
+// an empty query or SELECT *.
+func csvQueryColumns(d *DataSet, query string) ([]string, error) {
+	columns := columnNames(d.Columns)
+	if len(strings.TrimSpace(query)) == 0 {
+		return columns, nil
+	}
+
+	q, err := parseCSVQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(q.columns) > 0 {
+		return q.columns, nil
+	}
+
+	return columns, nil
+}
+
+// Describe runs the query for no rows and reads the columns of the result.
+// It runs in a transaction that is rolled back, so nothing the query may do
+// is kept.
+func (s *sqlSource) Describe(query string, args []interface{}) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	rows, err := tx.Query("SELECT * FROM ("+query+") AS hermes_describe LIMIT 0", sqlArgs(args)...)
+	if err != nil {
+		redact.Println(err)
+		return nil, errors.New(ErrorCouldNotDescribeQuery)
+	}
+	defer rows.Close()
+
+	return rows.Columns()
+}
+
+func (s pooledSource) Describe(query string, args []interface{}) ([]string, error) {
+	if describer, ok := s.Source.(Describer); ok {
+		return describer.Describe(query, args)
+	}
+	return nil, errors.New(ErrorCouldNotDescribeQuery)
+}
+
+func (s *tunnelledSource) Describe(query string, args []interface{}) ([]string, error) {
+	if describer, ok := s.Source.(Describer); ok {
+		return describer.Describe(query, args)
+	}
+	return nil, errors.New(ErrorCouldNotDescribeQuery)
+}
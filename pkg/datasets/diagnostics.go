@@ -0,0 +1,488 @@
+package datasets
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/common/redact"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var (
+	DiagnosticsTimeout = 5 * time.Second
+	sqlTableReference  = regexp.MustCompile(`(?i)\b(?:from|join)\s+((?:"[^"]+"|[a-z_][a-z0-9_$]*)(?:\.(?:"[^"]+"|[a-z_][a-z0-9_$]*))?)`)
+)
+
+const (
+	StepOk      = "ok"
+	StepFailed  = "failed"
+	StepSkipped = "skipped"
+)
+
+// DiagnosticStep is the outcome of one stage of connecting to a dataset.
+type DiagnosticStep struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+	Detail    string  `json:"detail,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type TLSInfo struct {
+	Version     string   `json:"version,omitempty"`
+	CipherSuite string   `json:"cipherSuite,omitempty"`
+	Verified    bool     `json:"verified"`
+	Chain       []string `json:"chain,omitempty"`
+}
+
+type TableAccess struct {
+	Table     string `json:"table"`
+	CanSelect bool   `json:"canSelect"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Diagnostics reports every stage of connecting to a stored dataset, from
+// name resolution to the privileges its notifications rely on.
+type Diagnostics struct {
+	DatasetId      string          `json:"datasetId"`
+	Ok             bool            `json:"ok"`
+	Host           string          `json:"host,omitempty"`
+	Port           string          `json:"port,omitempty"`
+	Addresses      []string        `json:"addresses,omitempty"`
+	DNS            *DiagnosticStep `json:"dns,omitempty"`
+	TCP            *DiagnosticStep `json:"tcp,omitempty"`
+	TLS            *DiagnosticStep `json:"tls,omitempty"`
+	TLSInfo        *TLSInfo        `json:"tlsInfo,omitempty"`
+	SSH            *DiagnosticStep `json:"ssh,omitempty"`
+	Authentication *DiagnosticStep `json:"authentication"`
+	ServerVersion  string          `json:"serverVersion,omitempty"`
+	Tables         []TableAccess   `json:"tables,omitempty"`
+}
+
+type DiagnoseRequest struct {
+	Tables []string `json:"tables"`
+}
+
+// endpoint is the network address behind a connection, when it has one.
+// verifyHost is false when only the certificate chain is checked, and
+// tlsConfig holds the CA and client certificate of the TLS options.
+type endpoint struct {
+	host       string
+	port       string
+	tls        bool
+	startTLS   bool
+	verify     bool
+	verifyHost bool
+	tlsConfig  *tls.Config
+}
+
+// DiagnoseDataset tests a stored dataset with its resolved credentials.
+// Tables are the ones read by the notifications using the dataset, plus the
+// ones listed in the request body.
+func DiagnoseDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository, notificationRepo crud.CrudRepository, id string) (
+	*Diagnostics,
+	error,
+) {
+	var r DiagnoseRequest
+	if len(req.Body) > 0 {
+		if err := json.Unmarshal([]byte(req.Body), &r); err != nil {
+			return nil, errors.New(ErrorInvalidConnectionData)
+		}
+	}
+
+	d, _ := FetchDataset(id, repo)
+	if d != nil && len(d.Name) == 0 {
+		return nil, errors.New(ErrorDatasetDoesNotExists)
+	}
+
+	tables := r.Tables
+	if d.Type == "sql" && notificationRepo != nil {
+		queries, err := queriesUsingDataset(notificationRepo, d.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range queries {
+			tables = append(tables, ReferencedTables(q)...)
+		}
+	}
+
+	return Diagnose(d.Id, d.Connection(), tables), nil
+}
+
+func Diagnose(datasetId string, c Connection, tables []string) *Diagnostics {
+	result := &Diagnostics{DatasetId: datasetId, Authentication: &DiagnosticStep{Status: StepSkipped}}
+
+	secret, _, err := resolveCredentials(c)
+	if err != nil {
+		result.Authentication = failedStep(0, err)
+		return result
+	}
+
+	if c.SSH != nil {
+		if !diagnoseTunnel(result, c, secret) {
+			return result
+		}
+	} else if e, ok := connectionEndpoint(c, secret); ok {
+		result.Host, result.Port = e.host, e.port
+		if !diagnoseNetwork(result, e) {
+			return result
+		}
+	}
+
+	start := time.Now()
+	source, err := OpenSource(c, secret)
+	if err != nil {
+		result.Authentication = failedStep(time.Since(start), err)
+		return result
+	}
+	defer source.Close()
+
+	if err := source.Ping(); err != nil {
+		result.Authentication = failedStep(time.Since(start), err)
+		return result
+	}
+	result.Authentication = &DiagnosticStep{Status: StepOk, LatencyMs: milliseconds(time.Since(start))}
+	result.Ok = true
+
+	if c.Type == "sql" {
+		if rows, err := source.Query("SHOW server_version"); err == nil && len(rows) > 0 {
+			result.ServerVersion = fmt.Sprint(rows[0]["server_version"])
+		}
+		result.Tables = tableAccess(source, tables)
+		for _, t := range result.Tables {
+			if !t.CanSelect {
+				result.Ok = false
+			}
+		}
+	}
+
+	return result
+}
+
+// diagnoseNetwork runs the DNS, TCP and TLS steps and reports whether the
+// remaining steps are worth running.
+func diagnoseNetwork(result *Diagnostics, e endpoint) bool {
+	start := time.Now()
+	addresses, err := net.LookupHost(e.host)
+	if err != nil {
+		result.DNS = failedStep(time.Since(start), err)
+		return false
+	}
+	result.Addresses = addresses
+	result.DNS = &DiagnosticStep{Status: StepOk, LatencyMs: milliseconds(time.Since(start)), Detail: strings.Join(addresses, ", ")}
+
+	start = time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(e.host, e.port), DiagnosticsTimeout)
+	if err != nil {
+		result.TCP = failedStep(time.Since(start), err)
+		return false
+	}
+	defer conn.Close()
+	result.TCP = &DiagnosticStep{Status: StepOk, LatencyMs: milliseconds(time.Since(start)), Detail: conn.RemoteAddr().String()}
+
+	if !e.tls && !e.startTLS {
+		result.TLS = &DiagnosticStep{Status: StepSkipped, Detail: "TLS is not requested by this connection"}
+		return true
+	}
+
+	conn.SetDeadline(time.Now().Add(DiagnosticsTimeout))
+	start = time.Now()
+	if e.startTLS {
+		accepted, err := postgresSSLRequest(conn)
+		if err != nil {
+			result.TLS = failedStep(time.Since(start), err)
+			return true
+		}
+		if !accepted {
+			result.TLS = &DiagnosticStep{Status: StepFailed, LatencyMs: milliseconds(time.Since(start)), Error: "server does not accept TLS connections"}
+			return true
+		}
+	}
+
+	// verification is done separately so the chain can be reported even
+	// when it is not trusted
+	config := &tls.Config{ServerName: e.host, InsecureSkipVerify: true}
+	var roots *x509.CertPool
+	if e.tlsConfig != nil {
+		config.Certificates = e.tlsConfig.Certificates
+		if len(e.tlsConfig.ServerName) > 0 {
+			config.ServerName = e.tlsConfig.ServerName
+		}
+		roots = e.tlsConfig.RootCAs
+	}
+
+	client := tls.Client(conn, config)
+	if err := client.Handshake(); err != nil {
+		result.TLS = failedStep(time.Since(start), err)
+		return true
+	}
+
+	host := config.ServerName
+	if !e.verifyHost {
+		host = ""
+	}
+
+	state := client.ConnectionState()
+	info, verifyErr := describeTLS(state, host, roots)
+	result.TLSInfo = info
+	result.TLS = &DiagnosticStep{Status: StepOk, LatencyMs: milliseconds(time.Since(start)), Detail: info.Version + " " + info.CipherSuite}
+	if verifyErr != nil {
+		result.TLS.Error = verifyErr.Error()
+		if e.verify {
+			result.TLS.Status = StepFailed
+		}
+	}
+
+	return true
+}
+
+// diagnoseTunnel runs the DNS and TCP steps against the jump host, then
+// opens the ssh session and reaches the dataset host through it.
+func diagnoseTunnel(result *Diagnostics, c Connection, secret string) bool {
+	host, port, _ := net.SplitHostPort(c.SSH.address())
+	if !diagnoseNetwork(result, endpoint{host: host, port: port}) {
+		return false
+	}
+	result.TLS = nil
+
+	start := time.Now()
+	tunnel, err := openSSHTunnel(c.SSH, c.Provider)
+	if err != nil {
+		result.SSH = failedStep(time.Since(start), err)
+		return false
+	}
+	defer tunnel.Close()
+
+	result.SSH = &DiagnosticStep{Status: StepOk, LatencyMs: milliseconds(time.Since(start)), Detail: c.SSH.User + "@" + c.SSH.address()}
+
+	e, ok := connectionEndpoint(c, secret)
+	if !ok {
+		return true
+	}
+	result.Host, result.Port = e.host, e.port
+
+	conn, err := tunnel.Dial("tcp", net.JoinHostPort(e.host, e.port))
+	if err != nil {
+		result.SSH = failedStep(time.Since(start), err)
+		return false
+	}
+	conn.Close()
+	result.SSH.Detail += " -> " + net.JoinHostPort(e.host, e.port)
+
+	return true
+}
+
+// postgresSSLRequest asks a Postgres server to upgrade the connection to
+// TLS, as the first message of the startup flow.
+func postgresSSLRequest(conn net.Conn) (bool, error) {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], 80877103)
+	if _, err := conn.Write(request); err != nil {
+		return false, err
+	}
+
+	response := make([]byte, 1)
+	if _, err := conn.Read(response); err != nil {
+		return false, err
+	}
+
+	return response[0] == 'S', nil
+}
+
+func describeTLS(state tls.ConnectionState, host string, roots *x509.CertPool) (*TLSInfo, error) {
+	info := &TLSInfo{
+		Version:     tlsVersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		Chain:       describeChain(state.PeerCertificates),
+	}
+
+	err := verifyChain(state.PeerCertificates, roots, host)
+	info.Verified = err == nil
+
+	return info, err
+}
+
+func tableAccess(source Source, tables []string) []TableAccess {
+	result := []TableAccess{}
+	for _, table := range uniqueStrings(tables) {
+		access := TableAccess{Table: table}
+		rows, err := source.Query("SELECT has_table_privilege(current_user, $1, 'SELECT') AS allowed", table)
+		if err != nil {
+			access.Error = redact.String(err.Error())
+		} else if len(rows) > 0 {
+			access.CanSelect = rows[0]["allowed"] == true
+		}
+		result = append(result, access)
+	}
+
+	return result
+}
+
+// ReferencedTables returns the tables a SQL query reads from.
+func ReferencedTables(query string) []string {
+	tables := []string{}
+	for _, m := range sqlTableReference.FindAllStringSubmatch(query, -1) {
+		tables = append(tables, m[1])
+	}
+
+	return uniqueStrings(tables)
+}
+
+// queriesUsingDataset reads the notifications table without depending on
+// the notifications package, which depends on this one.
+func queriesUsingDataset(notificationRepo crud.CrudRepository, datasetId string) ([]string, error) {
+	var items []struct {
+		Query struct {
+			DataSetId string `json:"datasetId"`
+			Query     string `json:"query"`
+		} `json:"query"`
+	}
+
+	if _, err := notificationRepo.List(&items); err != nil {
+		return nil, err
+	}
+
+	queries := []string{}
+	for _, item := range items {
+		if item.Query.DataSetId == datasetId {
+			queries = append(queries, item.Query.Query)
+		}
+	}
+
+	return queries, nil
+}
+
+// connectionEndpoint returns the endpoint of a connection, with its TLS
+// options applied over the settings of the DSN or URL.
+func connectionEndpoint(c Connection, secret string) (endpoint, bool) {
+	e, ok := networkEndpoint(c, secret)
+	if !ok || c.TLS == nil {
+		return e, ok
+	}
+
+	if c.Type == "sql" {
+		e.startTLS = c.TLS.Mode != TLSModeDisable
+	}
+	e.verify = c.TLS.Mode == TLSModeVerifyCA || c.TLS.Mode == TLSModeVerifyFull
+	e.verifyHost = c.TLS.Mode != TLSModeVerifyCA
+	e.tlsConfig, _ = buildTLSConfig(c.TLS, c.Provider)
+
+	return e, true
+}
+
+func networkEndpoint(c Connection, secret string) (endpoint, bool) {
+	switch c.Type {
+	case "sql":
+		return sqlEndpoint(secret)
+	case "http":
+		if c.HTTP == nil {
+			return endpoint{}, false
+		}
+		u, err := url.Parse(c.HTTP.URL)
+		if err != nil || len(u.Hostname()) == 0 {
+			return endpoint{}, false
+		}
+		e := endpoint{host: u.Hostname(), port: u.Port(), tls: u.Scheme == "https", verify: true, verifyHost: true}
+		if len(e.port) == 0 {
+			e.port = "80"
+			if e.tls {
+				e.port = "443"
+			}
+		}
+		return e, true
+	}
+
+	return endpoint{}, false
+}
+
+// sqlEndpoint reads the host, port and sslmode from either form of
+// Postgres DSN.
+func sqlEndpoint(dsn string) (endpoint, bool) {
+	e := endpoint{port: "5432"}
+	// lib/pq requires TLS unless told otherwise
+	sslmode := "require"
+
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		e.host = u.Hostname()
+		if len(u.Port()) > 0 {
+			e.port = u.Port()
+		}
+		if mode := u.Query().Get("sslmode"); len(mode) > 0 {
+			sslmode = mode
+		}
+	} else {
+		for _, pair := range strings.Fields(dsn) {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			value := strings.Trim(kv[1], "'")
+			switch kv[0] {
+			case "host":
+				e.host = value
+			case "port":
+				e.port = value
+			case "sslmode":
+				sslmode = value
+			}
+		}
+	}
+
+	if len(e.host) == 0 || strings.HasPrefix(e.host, "/") {
+		return endpoint{}, false
+	}
+	e.startTLS = sslmode != "disable"
+	e.verify = sslmode == "verify-ca" || sslmode == "verify-full"
+	e.verifyHost = sslmode != "verify-ca"
+
+	return e, true
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+
+	return fmt.Sprintf("0x%04x", version)
+}
+
+func failedStep(elapsed time.Duration, err error) *DiagnosticStep {
+	return &DiagnosticStep{Status: StepFailed, LatencyMs: milliseconds(elapsed), Error: redact.String(err.Error())}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, v := range values {
+		if len(v) == 0 || seen[v] {
+			continue
+		}
+		seen[v] = true
+		result = append(result, v)
+	}
+	sort.Strings(result)
+
+	return result
+}
@@ -0,0 +1,235 @@
+package datasets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var (
+	ErrorInvalidDynamoDBOptions = "invalid dynamodb dataset options"
+	ErrorInvalidDynamoDBQuery   = "invalid dynamodb query"
+)
+
+// DynamoDBOptions points a "dynamodb" dataset at a table, and optionally at
+// one of its indexes. Tables are read with the lambda's own AWS session.
+type DynamoDBOptions struct {
+	Table string `json:"table"`
+	Index string `json:"index,omitempty"`
+}
+
+// DynamoDBQuery is the JSON document used as Notification.Query for
+// dynamodb datasets. String values of the form "$n" are replaced by the
+// query arguments before being sent to DynamoDB.
+type DynamoDBQuery struct {
+	Operation    string                 `json:"operation"`
+	Index        string                 `json:"index,omitempty"`
+	KeyCondition string                 `json:"keyCondition,omitempty"`
+	Filter       string                 `json:"filter,omitempty"`
+	Projection   string                 `json:"projection,omitempty"`
+	Names        map[string]string      `json:"names,omitempty"`
+	Values       map[string]interface{} `json:"values,omitempty"`
+	Limit        int                    `json:"limit,omitempty"`
+}
+
+type dynamoDBDriver struct {
+	dynaClient dynamodbiface.DynamoDBAPI
+}
+
+type dynamoDBSource struct {
+	dynaClient dynamodbiface.DynamoDBAPI
+	options    DynamoDBOptions
+}
+
+func NewDynamoDBDriver(dynaClient dynamodbiface.DynamoDBAPI) Driver {
+	return dynamoDBDriver{dynaClient: dynaClient}
+}
+
+func (d dynamoDBDriver) Open(c Connection, r Resolved) (Source, error) {
+	if err := ValidateDynamoDBOptions(c.DynamoDB); err != nil {
+		return nil, err
+	}
+
+	return &dynamoDBSource{dynaClient: d.dynaClient, options: *c.DynamoDB}, nil
+}
+
+func (s *dynamoDBSource) Ping() error {
+	_, err := s.dynaClient.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(s.options.Table)})
+	return err
+}
+
+func (s *dynamoDBSource) Query(query string, args ...interface{}) ([]Row, error) {
+	q, err := ParseDynamoDBQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	index := s.options.Index
+	if len(q.Index) > 0 {
+		index = q.Index
+	}
+
+	values, err := dynamoDBValues(q.Values, args)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Row{}
+	collect := func(items []map[string]*dynamodb.AttributeValue) bool {
+		for _, item := range items {
+			var record map[string]interface{}
+			if err = dynamodbattribute.UnmarshalMap(item, &record); err != nil {
+				return false
+			}
+			row := Row{}
+			flattenInto(row, "", record)
+			result = append(result, row)
+
+			if q.Limit > 0 && len(result) >= q.Limit {
+				return false
+			}
+		}
+		return true
+	}
+
+	if q.Operation == "query" {
+		input := &dynamodb.QueryInput{
+			TableName:                 aws.String(s.options.Table),
+			KeyConditionExpression:    aws.String(q.KeyCondition),
+			ExpressionAttributeValues: values,
+		}
+		if len(index) > 0 {
+			input.IndexName = aws.String(index)
+		}
+		if len(q.Filter) > 0 {
+			input.FilterExpression = aws.String(q.Filter)
+		}
+		if len(q.Projection) > 0 {
+			input.ProjectionExpression = aws.String(q.Projection)
+		}
+		if len(q.Names) > 0 {
+			input.ExpressionAttributeNames = aws.StringMap(q.Names)
+		}
+
+		queryErr := s.dynaClient.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			return collect(page.Items)
+		})
+		if queryErr != nil {
+			return nil, queryErr
+		}
+		return result, err
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:                 aws.String(s.options.Table),
+		ExpressionAttributeValues: values,
+	}
+	if len(index) > 0 {
+		input.IndexName = aws.String(index)
+	}
+	if len(q.Filter) > 0 {
+		input.FilterExpression = aws.String(q.Filter)
+	}
+	if len(q.Projection) > 0 {
+		input.ProjectionExpression = aws.String(q.Projection)
+	}
+	if len(q.Names) > 0 {
+		input.ExpressionAttributeNames = aws.StringMap(q.Names)
+	}
+
+	scanErr := s.dynaClient.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		return collect(page.Items)
+	})
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return result, err
+}
+
+func (s *dynamoDBSource) Close() error {
+	return nil
+}
+
+func ValidateDynamoDBOptions(options *DynamoDBOptions) error {
+	if options == nil || len(options.Table) == 0 {
+		return errors.New(ErrorInvalidDynamoDBOptions + ": table is required")
+	}
+
+	return nil
+}
+
+func ParseDynamoDBQuery(query string) (*DynamoDBQuery, error) {
+	var q DynamoDBQuery
+	if err := json.Unmarshal([]byte(query), &q); err != nil {
+		return nil, errors.New(ErrorInvalidDynamoDBQuery + ": query must be a JSON document")
+	}
+
+	q.Operation = strings.ToLower(q.Operation)
+	switch q.Operation {
+	case "query":
+		if len(q.KeyCondition) == 0 {
+			return nil, errors.New(ErrorInvalidDynamoDBQuery + ": query operation requires keyCondition")
+		}
+	case "", "scan":
+		q.Operation = "scan"
+		if len(q.KeyCondition) > 0 {
+			return nil, errors.New(ErrorInvalidDynamoDBQuery + ": scan operation does not accept keyCondition")
+		}
+	default:
+		return nil, errors.New(ErrorInvalidDynamoDBQuery + ": operation must be query or scan")
+	}
+
+	return &q, nil
+}
+
+func dynamoDBValues(values map[string]interface{}, args []interface{}) (map[string]*dynamodb.AttributeValue, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	result := map[string]*dynamodb.AttributeValue{}
+	for name, value := range values {
+		if s, ok := value.(string); ok && strings.HasPrefix(s, "$") {
+			if i, err := strconv.Atoi(s[1:]); err == nil {
+				if i < 1 || i > len(args) {
+					return nil, fmt.Errorf("%s: missing argument %s", ErrorInvalidDynamoDBQuery, s)
+				}
+				value = args[i-1]
+			}
+		}
+
+		av, err := dynamodbattribute.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", ErrorInvalidDynamoDBQuery, err)
+		}
+		result[name] = av
+	}
+
+	return result, nil
+}
+
+// flattenInto copies nested maps into row using dotted column names, so an
+// item {"address": {"city": "Lisbon"}} yields the column "address.city".
+// Templates read such columns as {{index .Row "address.city"}}, as
+// .Row.address.city would look for a column named address.
+func flattenInto(row Row, prefix string, record map[string]interface{}) {
+	for k, v := range record {
+		name := k
+		if len(prefix) > 0 {
+			name = prefix + "." + k
+		}
+
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenInto(row, name, nested)
+			continue
+		}
+		row[name] = v
+	}
+}
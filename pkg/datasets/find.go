@@ -0,0 +1,65 @@
+package datasets
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Finder is implemented by sources that can look up the rows of a query
+// whose column holds one of values without reading the whole result.
+// Values are compared case insensitively when fold is set.
+type Finder interface {
+	Find(query string, args []interface{}, column string, values []string, fold bool) ([]Row, error)
+}
+
+// FindRows looks rows up with source, falling back to reading the query
+// through and keeping the rows that match for sources that can't find.
+func FindRows(source Source, query string, args []interface{}, column string, values []string, fold bool) ([]Row, error) {
+	if finder, ok := source.(Finder); ok {
+		return finder.Find(query, args, column, values, fold)
+	}
+
+	rows := []Row{}
+	err := StreamRows(source, query, args, 0, func(row Row) error {
+		value := recipientValue(row, column)
+		for _, v := range values {
+			if value == v || (fold && strings.EqualFold(value, v)) {
+				rows = append(rows, row)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// Find filters the query in the database, on the column of its result.
+func (s *sqlSource) Find(query string, args []interface{}, column string, values []string, fold bool) ([]Row, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	match := "hermes_find." + pq.QuoteIdentifier(column)
+	if fold {
+		match = "lower(" + match + ")"
+		lowered := make([]string, len(values))
+		for i, v := range values {
+			lowered[i] = strings.ToLower(v)
+		}
+		values = lowered
+	}
+
+	filtered := fmt.Sprintf("SELECT * FROM (%s) AS hermes_find WHERE %s = ANY($%d)", query, match, len(args)+1)
+	return s.Query(filtered, append(append([]interface{}{}, args...), values)...)
+}
+
+func (s pooledSource) Find(query string, args []interface{}, column string, values []string, fold bool) ([]Row, error) {
+	return FindRows(s.Source, query, args, column, values, fold)
+}
+
+func (s *tunnelledSource) Find(query string, args []interface{}, column string, values []string, fold bool) ([]Row, error) {
+	return FindRows(s.Source, query, args, column, values, fold)
+}
@@ -1,14 +1,16 @@
 package datasets
 
 import (
-	"fmt"
+	"errors"
 	"hermes/pkg/common/crud"
+	"hermes/pkg/common/redact"
 	"hermes/pkg/handlers"
 	"net/http"
+	"strconv"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
 var ErrorMethodNotAllowed = "method Not allowed"
@@ -17,6 +19,13 @@ type ErrorBody struct {
 	ErrorMsg *string `json:"error,omitempty"`
 }
 
+// ConnectionErrorBody adds the certificates presented by the server to a
+// failed connection test.
+type ConnectionErrorBody struct {
+	ErrorMsg *string  `json:"error,omitempty"`
+	TLS      *TLSInfo `json:"tls,omitempty"`
+}
+
 func GetDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
@@ -26,71 +35,159 @@ func GetDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (
 		// Get single dataset
 		result, err := FetchDataset(id, repo)
 		if err != nil {
-			return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+			return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{aws.String(redact.String(err.Error()))})
 		}
 
-		return handlers.ApiResponse(http.StatusOK, result)
+		return handlers.ApiResponse(http.StatusOK, result.Redacted())
 	}
 
 	// Get list of datasets
 	result, err := FetchDatasets(repo)
 	if err != nil {
 		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
+			aws.String(redact.String(err.Error())),
 		})
 	}
-	return handlers.ApiResponse(http.StatusOK, result)
+
+	redacted := make([]DataSet, len(*result))
+	for i, d := range *result {
+		redacted[i] = d.Redacted()
+	}
+	return handlers.ApiResponse(http.StatusOK, redacted)
 }
 
-func NewDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository, ssmClient *ssm.SSM) (
+func NewDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	result, err := CreateDataset(req, repo, ssmClient)
+	result, err := CreateDataset(req, repo)
 	if err != nil {
 		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
+			aws.String(redact.String(err.Error())),
 		})
 	}
-	fmt.Println(result)
-	return handlers.ApiResponse(http.StatusCreated, result)
+	redact.Println(result.Redacted())
+	return handlers.ApiResponse(http.StatusCreated, result.Redacted())
 }
 
-func SaveDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository, ssmClient *ssm.SSM) (
+func SaveDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	result, err := UpdateDataset(req, repo, ssmClient)
+	result, err := UpdateDataset(req, repo)
 	if err != nil {
 		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
+			aws.String(redact.String(err.Error())),
 		})
 	}
-	return handlers.ApiResponse(http.StatusOK, result)
+	return handlers.ApiResponse(http.StatusOK, result.Redacted())
 }
 
-func RemoveDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository, ssmClient *ssm.SSM) (
+func RemoveDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository, s3Client s3iface.S3API) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	err := DeleteDataset(req, repo, ssmClient)
+	err := DeleteDataset(req, repo, s3Client)
 	if err != nil {
 		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
+			aws.String(redact.String(err.Error())),
 		})
 	}
 	return handlers.ApiResponse(http.StatusOK, nil)
 }
 
-func TestConnection(req events.APIGatewayProxyRequest, ssmClient *ssm.SSM) (
+func TestConnection(req events.APIGatewayProxyRequest) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	err := EnsureConnection(req, ssmClient)
+	err := EnsureConnection(req)
+	var tlsErr *TLSError
+	if errors.As(err, &tlsErr) {
+		return handlers.ApiResponse(http.StatusBadRequest, ConnectionErrorBody{
+			aws.String(redact.String(err.Error())),
+			tlsErr.Info,
+		})
+	}
 	if err != nil {
 		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
+			aws.String(redact.String(err.Error())),
 		})
 	}
 	return handlers.ApiResponse(http.StatusOK, nil)
 }
+
+func UploadDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository, s3Client s3iface.S3API, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := UploadCSV(req, repo, s3Client, id)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(redact.String(err.Error())),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, result.Redacted())
+}
+
+func RevealCredentials(req events.APIGatewayProxyRequest, repo crud.CrudRepository, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	if !IsRevealAuthorised(req) {
+		return handlers.ApiResponse(http.StatusForbidden, ErrorBody{
+			aws.String(ErrorRevealNotAuthorised),
+		})
+	}
+
+	result, err := RevealDatasetCredentials(req, repo, id)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(redact.String(err.Error())),
+		})
+	}
+	return handlers.SensitiveApiResponse(http.StatusOK, result)
+}
+
+func RotateCredentials(req events.APIGatewayProxyRequest, repo crud.CrudRepository, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := RotateDatasetCredentials(req, repo, id)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(redact.String(err.Error())),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, result.Redacted())
+}
+
+func TestDataset(req events.APIGatewayProxyRequest, repo crud.CrudRepository, notificationRepo crud.CrudRepository, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := DiagnoseDataset(req, repo, notificationRepo, id)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(redact.String(err.Error())),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, result)
+}
+
+func GetDatasetHealth(req events.APIGatewayProxyRequest, repo crud.CrudRepository, healthRepo *HealthRepository, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	limit, err := strconv.Atoi(req.QueryStringParameters["limit"])
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	result, err := FetchDatasetHealth(id, repo, healthRepo, limit)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(redact.String(err.Error())),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, result)
+}
@@ -0,0 +1,231 @@
+package datasets
+
+import (
+	"errors"
+	"hermes/pkg/common/crud"
+	BaseErrors "hermes/pkg/common/errors"
+	"hermes/pkg/common/redact"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+	HealthUnknown   = "unknown"
+)
+
+var (
+	HealthCheckTimeout      = 10 * time.Second
+	HealthCheckConcurrency  = 8
+	ErrorHealthCheckTimeout = "health check timed out"
+)
+
+// HealthStatus is the latest known health of a dataset, kept on the
+// dataset itself.
+type HealthStatus struct {
+	Status              string `json:"status"`
+	LastCheckedAt       string `json:"lastCheckedAt,omitempty"`
+	LastHealthyAt       string `json:"lastHealthyAt,omitempty"`
+	LastError           string `json:"lastError,omitempty"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+}
+
+// HealthCheck is one entry of the health history of a dataset.
+type HealthCheck struct {
+	DatasetId string  `json:"datasetId"`
+	CheckedAt string  `json:"checkedAt"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+	ExpiresAt int64   `json:"expiresAt,omitempty"`
+}
+
+type DatasetHealth struct {
+	DatasetId string        `json:"datasetId"`
+	Health    *HealthStatus `json:"health"`
+	History   []HealthCheck `json:"history"`
+}
+
+// HealthRepository stores health checks in a table keyed by datasetId and
+// checkedAt. Old checks expire through the table TTL on expiresAt.
+type HealthRepository struct {
+	dynaClient dynamodbiface.DynamoDBAPI
+	tableName  string
+}
+
+func InitHealthRepo(t string, d dynamodbiface.DynamoDBAPI) *HealthRepository {
+	return &HealthRepository{
+		dynaClient: d,
+		tableName:  t,
+	}
+}
+
+func (h *HealthRepository) Record(check HealthCheck) error {
+	av, err := dynamodbattribute.MarshalMap(check)
+	if err != nil {
+		return errors.New(BaseErrors.ErrorCouldNotMarshalItem)
+	}
+
+	_, err = h.dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(h.tableName),
+	})
+	if err != nil {
+		redact.Println(err)
+		return errors.New(BaseErrors.ErrorCouldNotDynamoPutItem)
+	}
+
+	return nil
+}
+
+// History returns the latest checks of a dataset, newest first.
+func (h *HealthRepository) History(datasetId string, limit int) ([]HealthCheck, error) {
+	result, err := h.dynaClient.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(h.tableName),
+		KeyConditionExpression: aws.String("datasetId = :id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {S: aws.String(datasetId)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(int64(limit)),
+	})
+	if err != nil {
+		redact.Println(err)
+		return nil, errors.New(BaseErrors.ErrorFailedToFetchRecord)
+	}
+
+	checks := []HealthCheck{}
+	if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &checks); err != nil {
+		return nil, errors.New(BaseErrors.ErrorFailedToUnmarshalRecord)
+	}
+
+	return checks, nil
+}
+
+func FetchDatasetHealth(id string, repo crud.CrudRepository, healthRepo *HealthRepository, limit int) (*DatasetHealth, error) {
+	d, _ := FetchDataset(id, repo)
+	if d != nil && len(d.Name) == 0 {
+		return nil, errors.New(ErrorDatasetDoesNotExists)
+	}
+
+	history, err := healthRepo.History(id, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	health := d.Health
+	if health == nil {
+		health = &HealthStatus{Status: HealthUnknown}
+	}
+
+	return &DatasetHealth{DatasetId: id, Health: health, History: history}, nil
+}
+
+// CheckDataset pings a stored dataset through the shared connection
+// manager and updates its health status.
+func CheckDataset(d *DataSet, now time.Time) HealthCheck {
+	check := HealthCheck{
+		DatasetId: d.Id,
+		CheckedAt: now.UTC().Format(time.RFC3339Nano),
+		Status:    HealthHealthy,
+		ExpiresAt: now.Add(time.Duration(healthRetentionDays()) * 24 * time.Hour).Unix(),
+	}
+
+	start := time.Now()
+	err := pingWithTimeout(d)
+	check.LatencyMs = milliseconds(time.Since(start))
+
+	if d.Health == nil {
+		d.Health = &HealthStatus{}
+	}
+	d.Health.LastCheckedAt = check.CheckedAt
+
+	if err != nil {
+		check.Status = HealthUnhealthy
+		check.Error = redact.String(err.Error())
+		d.Health.Status = HealthUnhealthy
+		d.Health.LastError = check.Error
+		d.Health.ConsecutiveFailures++
+		return check
+	}
+
+	d.Health.Status = HealthHealthy
+	d.Health.LastError = ""
+	d.Health.LastHealthyAt = check.CheckedAt
+	d.Health.ConsecutiveFailures = 0
+
+	return check
+}
+
+// MonitorDatasets checks every dataset, records the checks and saves the
+// new health status on each dataset. It returns the checked datasets.
+func MonitorDatasets(repo crud.CrudRepository, healthRepo *HealthRepository) ([]DataSet, error) {
+	items, err := FetchDatasets(repo)
+	if err != nil {
+		return nil, err
+	}
+	all := *items
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, HealthCheckConcurrency)
+	now := time.Now()
+
+	for i := range all {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(d *DataSet) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			check := CheckDataset(d, now)
+			if err := healthRepo.Record(check); err != nil {
+				redact.Println("could not record health of dataset", d.Id, err)
+			}
+			// only health is written, so edits made during the run are kept
+			if err := repo.UpdateAttribute(d.Id, "health", d.Health); err != nil {
+				redact.Println("could not save health of dataset", d.Id, err)
+			}
+		}(&all[i])
+	}
+	wg.Wait()
+
+	return all, nil
+}
+
+// pingWithTimeout bounds the ping, as drivers may wait on unreachable
+// hosts for much longer than a scheduled run lasts.
+func pingWithTimeout(d *DataSet) error {
+	done := make(chan error, 1)
+	go func() {
+		source, err := OpenDataset(d)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer source.Close()
+		done <- source.Ping()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(HealthCheckTimeout):
+		return errors.New(ErrorHealthCheckTimeout)
+	}
+}
+
+// HealthFailureThreshold is the number of consecutive failed checks after
+// which notifications using a dataset are considered at risk.
+func HealthFailureThreshold() int {
+	return envInt("HEALTH_FAILURE_THRESHOLD", 3)
+}
+
+func healthRetentionDays() int {
+	return envInt("HEALTH_RETENTION_DAYS", 30)
+}
@@ -0,0 +1,371 @@
+package datasets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmespath/go-jmespath"
+)
+
+var (
+	ErrorInvalidHTTPOptions  = "invalid http dataset options"
+	ErrorInvalidJMESPath     = "invalid JMESPath expression"
+	ErrorHTTPRequestFailed   = "http dataset request failed"
+	ErrorInvalidHTTPResponse = "http dataset did not return JSON"
+	DefaultHTTPMaxPages      = 100
+	DefaultHTTPAuthHeader    = "Authorization"
+)
+
+var (
+	httpPlaceholder     = regexp.MustCompile(`\$(\d+)`)
+	httpLinkNext        = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+	httpPaginationTypes = map[string]bool{"page": true, "offset": true, "cursor": true, "link": true}
+)
+
+// HTTPOptions describes how to call the REST API behind an "http" dataset.
+// The resolved credentials are sent as the value of AuthHeader. $n
+// placeholders in URL and Body are replaced by the query arguments.
+type HTTPOptions struct {
+	URL        string            `json:"url"`
+	Method     string            `json:"method"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	AuthHeader string            `json:"authHeader,omitempty"`
+	Pagination *HTTPPagination   `json:"pagination,omitempty"`
+}
+
+// HTTPPagination walks through paginated responses.
+//
+//	page:   sends Param=1,2,3... until a page yields no rows
+//	offset: sends Param=0,n,2n... until a page yields fewer than Size rows
+//	cursor: sends Param=<value of NextPath in the previous response>
+//	link:   follows the URL at NextPath, or the rel="next" Link header
+type HTTPPagination struct {
+	Type      string `json:"type"`
+	Param     string `json:"param,omitempty"`
+	SizeParam string `json:"sizeParam,omitempty"`
+	Size      int    `json:"size,omitempty"`
+	NextPath  string `json:"nextPath,omitempty"`
+	MaxPages  int    `json:"maxPages,omitempty"`
+}
+
+type httpDriver struct {
+	client *http.Client
+}
+
+type httpSource struct {
+	client  *http.Client
+	options HTTPOptions
+	secret  string
+}
+
+func init() {
+	RegisterDriver("http", httpDriver{client: &http.Client{Timeout: 30 * time.Second}})
+}
+
+func (d httpDriver) Open(c Connection, r Resolved) (Source, error) {
+	if err := ValidateHTTPOptions(c.HTTP); err != nil {
+		return nil, err
+	}
+
+	client := d.client
+	if r.Dial != nil || r.TLS != nil {
+		transport := &http.Transport{TLSClientConfig: r.TLS}
+		if r.Dial != nil {
+			transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+				return r.Dial(network, address)
+			}
+		}
+		client = &http.Client{Timeout: d.client.Timeout, Transport: transport}
+	}
+
+	return &httpSource{client: client, options: *c.HTTP, secret: r.Secret}, nil
+}
+
+func (s *httpSource) Ping() error {
+	_, _, err := s.fetch(s.expand(s.options.URL, nil, true), s.expand(s.options.Body, nil, false))
+	return err
+}
+
+// Query treats query as a JMESPath expression evaluated against every page
+// of the response. The expression must select an array of objects.
+func (s *httpSource) Query(query string, args ...interface{}) ([]Row, error) {
+	expression, err := jmespath.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ErrorInvalidJMESPath, err)
+	}
+
+	var next *jmespath.JMESPath
+	pagination := s.options.Pagination
+	if pagination != nil && len(pagination.NextPath) > 0 {
+		if next, err = jmespath.Compile(pagination.NextPath); err != nil {
+			return nil, fmt.Errorf("%s: %v", ErrorInvalidJMESPath, err)
+		}
+	}
+
+	target := s.expand(s.options.URL, args, true)
+	body := s.expand(s.options.Body, args, false)
+
+	result := []Row{}
+	for page := 0; ; page++ {
+		pageURL := target
+		if pagination != nil && pagination.Type != "link" {
+			pageURL = s.paginate(target, page, len(result))
+		}
+
+		data, header, err := s.fetch(pageURL, body)
+		if err != nil {
+			return nil, err
+		}
+
+		extracted, err := expression.Search(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", ErrorInvalidJMESPath, err)
+		}
+		rows := toRows(extracted)
+		result = append(result, rows...)
+
+		if pagination == nil || page+1 >= httpMaxPages(pagination) {
+			break
+		}
+
+		switch pagination.Type {
+		case "page":
+			if len(rows) == 0 {
+				return result, nil
+			}
+		case "offset":
+			if len(rows) == 0 || (pagination.Size > 0 && len(rows) < pagination.Size) {
+				return result, nil
+			}
+		case "cursor":
+			cursor := searchString(next, data)
+			if len(cursor) == 0 {
+				return result, nil
+			}
+			target = withQueryParam(target, pagination.Param, cursor)
+		case "link":
+			link := searchString(next, data)
+			if next == nil {
+				if m := httpLinkNext.FindStringSubmatch(header.Get("Link")); m != nil {
+					link = m[1]
+				}
+			}
+			if len(link) == 0 {
+				return result, nil
+			}
+			target = resolveURL(target, link)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *httpSource) Close() error {
+	return nil
+}
+
+func (s *httpSource) fetch(target string, body string) (interface{}, http.Header, error) {
+	method := strings.ToUpper(s.options.Method)
+	if len(method) == 0 {
+		method = http.MethodGet
+	}
+
+	var reader io.Reader
+	if len(body) > 0 {
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return nil, nil, errors.New(ErrorInvalidHTTPOptions)
+	}
+	req.Header.Set("Accept", "application/json")
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range s.options.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(s.secret) > 0 {
+		authHeader := s.options.AuthHeader
+		if len(authHeader) == 0 {
+			authHeader = DefaultHTTPAuthHeader
+		}
+		req.Header.Set(authHeader, s.secret)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", ErrorHTTPRequestFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, fmt.Errorf("%s: %s", ErrorHTTPRequestFailed, resp.Status)
+	}
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", ErrorHTTPRequestFailed, err)
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, nil, errors.New(ErrorInvalidHTTPResponse)
+	}
+
+	return data, resp.Header, nil
+}
+
+func (s *httpSource) paginate(target string, page int, fetched int) string {
+	p := s.options.Pagination
+	if len(p.SizeParam) > 0 && p.Size > 0 {
+		target = withQueryParam(target, p.SizeParam, strconv.Itoa(p.Size))
+	}
+
+	switch p.Type {
+	case "page":
+		return withQueryParam(target, p.Param, strconv.Itoa(page+1))
+	case "offset":
+		return withQueryParam(target, p.Param, strconv.Itoa(fetched))
+	}
+
+	return target
+}
+
+// expand replaces $n placeholders with the query arguments. Placeholders
+// without an argument are left untouched.
+func (s *httpSource) expand(template string, args []interface{}, escape bool) string {
+	return httpPlaceholder.ReplaceAllStringFunc(template, func(m string) string {
+		i, _ := strconv.Atoi(m[1:])
+		if i < 1 || i > len(args) {
+			return m
+		}
+
+		value := fmt.Sprint(args[i-1])
+		if escape {
+			return url.QueryEscape(value)
+		}
+		return value
+	})
+}
+
+func ValidateHTTPOptions(options *HTTPOptions) error {
+	if options == nil {
+		return errors.New(ErrorInvalidHTTPOptions + ": missing http options")
+	}
+
+	u, err := url.Parse(options.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return errors.New(ErrorInvalidHTTPOptions + ": url must be an absolute http(s) URL")
+	}
+
+	switch strings.ToUpper(options.Method) {
+	case "", http.MethodGet, http.MethodPost:
+	default:
+		return errors.New(ErrorInvalidHTTPOptions + ": method must be GET or POST")
+	}
+
+	if p := options.Pagination; p != nil {
+		if !httpPaginationTypes[p.Type] {
+			return errors.New(ErrorInvalidHTTPOptions + ": pagination type must be page, offset, cursor or link")
+		}
+		if p.Type != "link" && len(p.Param) == 0 {
+			return errors.New(ErrorInvalidHTTPOptions + ": pagination param is required")
+		}
+		if p.Type == "cursor" && len(p.NextPath) == 0 {
+			return errors.New(ErrorInvalidHTTPOptions + ": cursor pagination requires nextPath")
+		}
+		if len(p.NextPath) > 0 {
+			if _, err := jmespath.Compile(p.NextPath); err != nil {
+				return fmt.Errorf("%s: %v", ErrorInvalidJMESPath, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func httpMaxPages(p *HTTPPagination) int {
+	if p.MaxPages > 0 {
+		return p.MaxPages
+	}
+
+	return DefaultHTTPMaxPages
+}
+
+// toRows flattens a JMESPath result into rows. Objects become rows as they
+// are and any other value is exposed under the "value" column.
+func toRows(data interface{}) []Row {
+	if data == nil {
+		return []Row{}
+	}
+
+	items, ok := data.([]interface{})
+	if !ok {
+		items = []interface{}{data}
+	}
+
+	rows := make([]Row, 0, len(items))
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			rows = append(rows, Row(m))
+			continue
+		}
+		rows = append(rows, Row{"value": item})
+	}
+
+	return rows
+}
+
+func searchString(expression *jmespath.JMESPath, data interface{}) string {
+	if expression == nil {
+		return ""
+	}
+
+	value, err := expression.Search(data)
+	if err != nil || value == nil {
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
+
+func withQueryParam(target string, name string, value string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+
+	q := u.Query()
+	q.Set(name, value)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+func resolveURL(base string, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+
+	return b.ResolveReference(r).String()
+}
@@ -0,0 +1,158 @@
+package datasets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PoolConfig bounds the connections kept open by a pooled Source.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// Pooler is implemented by sources backed by a connection pool.
+type Pooler interface {
+	SetPool(config PoolConfig)
+}
+
+// ConnectionManager keeps sources open across warm lambda invocations. Each
+// source is keyed by dataset id and a fingerprint of its credential version
+// and connection options, so a changed dataset never reuses a stale pool.
+// Sources are opened outside the lock, as opening may dial a tunnel, and
+// callers asking for a source that is being opened wait for that one.
+type ConnectionManager struct {
+	mu      sync.Mutex
+	config  PoolConfig
+	sources map[string]map[string]Source
+	opening map[string]*openingSource
+}
+
+// openingSource is a source being opened; done is closed once source or
+// err is set.
+type openingSource struct {
+	done   chan struct{}
+	source Source
+	err    error
+}
+
+// Connections is the manager shared by every handler in the lambda.
+var Connections = NewConnectionManager(PoolConfigFromEnv())
+
+func NewConnectionManager(config PoolConfig) *ConnectionManager {
+	return &ConnectionManager{config: config, sources: map[string]map[string]Source{}, opening: map[string]*openingSource{}}
+}
+
+// PoolConfigFromEnv reads DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS,
+// DB_CONN_MAX_LIFETIME and DB_CONN_MAX_IDLE_TIME. Durations use Go syntax,
+// e.g. "5m".
+func PoolConfigFromEnv() PoolConfig {
+	return PoolConfig{
+		MaxOpenConns:    envInt("DB_MAX_OPEN_CONNS", 5),
+		MaxIdleConns:    envInt("DB_MAX_IDLE_CONNS", 2),
+		ConnMaxLifetime: envDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+		ConnMaxIdleTime: envDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+	}
+}
+
+// Acquire returns the open source for the dataset, opening it on first use.
+// Sources opened for an older version of the dataset are closed. Callers
+// may Close the returned source; the underlying pool stays open.
+func (m *ConnectionManager) Acquire(datasetId string, version string, c Connection, secret string) (Source, error) {
+	fingerprint := connectionFingerprint(version, c)
+	key := datasetId + "|" + fingerprint
+
+	m.mu.Lock()
+	if source, ok := m.sources[datasetId][fingerprint]; ok {
+		m.mu.Unlock()
+		return pooledSource{source}, nil
+	}
+	if pending, ok := m.opening[key]; ok {
+		m.mu.Unlock()
+		<-pending.done
+		if pending.err != nil {
+			return nil, pending.err
+		}
+		return pooledSource{pending.source}, nil
+	}
+	pending := &openingSource{done: make(chan struct{})}
+	m.opening[key] = pending
+	m.mu.Unlock()
+
+	source, err := OpenSource(c, secret)
+	if err == nil {
+		if pooler, ok := source.(Pooler); ok {
+			pooler.SetPool(m.config)
+		}
+	}
+
+	m.mu.Lock()
+	delete(m.opening, key)
+	if err == nil {
+		m.closeLocked(datasetId)
+		m.sources[datasetId] = map[string]Source{fingerprint: source}
+	}
+	pending.source, pending.err = source, err
+	close(pending.done)
+	m.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	return pooledSource{source}, nil
+}
+
+// Evict closes every source opened for the dataset.
+func (m *ConnectionManager) Evict(datasetId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closeLocked(datasetId)
+}
+
+func (m *ConnectionManager) closeLocked(datasetId string) {
+	for _, source := range m.sources[datasetId] {
+		source.Close()
+	}
+	delete(m.sources, datasetId)
+}
+
+// pooledSource hides Close from callers so the pool outlives the request.
+type pooledSource struct {
+	Source
+}
+
+func (pooledSource) Close() error {
+	return nil
+}
+
+func connectionFingerprint(version string, c Connection) string {
+	options, _ := json.Marshal(c)
+	sum := sha256.Sum256(append([]byte(version+"|"), options...))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+func envInt(name string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v >= 0 {
+		return v
+	}
+
+	return fallback
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	if v, err := time.ParseDuration(strings.TrimSpace(os.Getenv(name))); err == nil && v >= 0 {
+		return v
+	}
+
+	return fallback
+}
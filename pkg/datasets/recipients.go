@@ -0,0 +1,214 @@
+package datasets
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrorInvalidRecipientMapping = "invalid recipient mapping. Map at least one of email, phone or deviceToken"
+	ErrorUnknownRecipientColumn  = "recipient mapping refers to a column the query does not return"
+)
+
+// RecipientMapping names the result columns holding each recipient field.
+// A dataset may declare one for all its queries and a notification query
+// may override any of its fields.
+type RecipientMapping struct {
+	Email       string `json:"email,omitempty"`
+	Phone       string `json:"phone,omitempty"`
+	DeviceToken string `json:"deviceToken,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+}
+
+// Recipient is a result row resolved through a recipient mapping. Data
+// keeps the whole row for templates and rules.
+type Recipient struct {
+	Email       string `json:"email,omitempty"`
+	Phone       string `json:"phone,omitempty"`
+	DeviceToken string `json:"deviceToken,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+	Data        Row    `json:"data"`
+}
+
+type recipientField struct {
+	name   string
+	column string
+}
+
+func (m RecipientMapping) fields() []recipientField {
+	return []recipientField{
+		{"email", m.Email},
+		{"phone", m.Phone},
+		{"deviceToken", m.DeviceToken},
+		{"locale", m.Locale},
+		{"timezone", m.Timezone},
+	}
+}
+
+// Column returns the column mapped to a recipient field, such as email,
+// or an empty string when the field is not mapped.
+func (m RecipientMapping) Column(field string) string {
+	for _, f := range m.fields() {
+		if f.name == field {
+			return f.column
+		}
+	}
+
+	return ""
+}
+
+// Merge returns the mapping with the non empty fields of override applied.
+// Either of them may be nil.
+func (m *RecipientMapping) Merge(override *RecipientMapping) *RecipientMapping {
+	if m == nil {
+		return override
+	}
+	if override == nil {
+		return m
+	}
+
+	merged := *m
+	if len(override.Email) > 0 {
+		merged.Email = override.Email
+	}
+	if len(override.Phone) > 0 {
+		merged.Phone = override.Phone
+	}
+	if len(override.DeviceToken) > 0 {
+		merged.DeviceToken = override.DeviceToken
+	}
+	if len(override.Locale) > 0 {
+		merged.Locale = override.Locale
+	}
+	if len(override.Timezone) > 0 {
+		merged.Timezone = override.Timezone
+	}
+
+	return &merged
+}
+
+// ValidateRecipientMapping checks a mapping on its own. A mapping is
+// optional, but one that is given must reach the recipient somehow.
+func ValidateRecipientMapping(m *RecipientMapping) error {
+	if m == nil {
+		return nil
+	}
+
+	if len(strings.TrimSpace(m.Email)) == 0 && len(strings.TrimSpace(m.Phone)) == 0 && len(strings.TrimSpace(m.DeviceToken)) == 0 {
+		return errors.New(ErrorInvalidRecipientMapping)
+	}
+
+	return nil
+}
+
+// CheckColumns verifies that every mapped column is one of columns.
+func (m RecipientMapping) CheckColumns(columns []string) error {
+	known := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		known[column] = true
+	}
+
+	for _, field := range m.fields() {
+		if len(field.column) > 0 && !known[field.column] {
+			return fmt.Errorf("%s: %s (%s)", ErrorUnknownRecipientColumn, field.column, field.name)
+		}
+	}
+
+	return nil
+}
+
+// Resolve reads the recipient fields of a row.
+func (m RecipientMapping) Resolve(row Row) Recipient {
+	return Recipient{
+		Email:       recipientValue(row, m.Email),
+		Phone:       recipientValue(row, m.Phone),
+		DeviceToken: recipientValue(row, m.DeviceToken),
+		Locale:      recipientValue(row, m.Locale),
+		Timezone:    recipientValue(row, m.Timezone),
+		Data:        row,
+	}
+}
+
+// ResolveRecipients resolves each row through the mapping. A field whose
+// column a row leaves out is empty, as optional attributes of sparse items
+// may be missing from any row; the mapping is checked against the query
+// columns with CheckRecipientColumns instead.
+func ResolveRecipients(m *RecipientMapping, rows []Row) []Recipient {
+	if m == nil {
+		return nil
+	}
+
+	recipients := make([]Recipient, len(rows))
+	for i, row := range rows {
+		recipients[i] = m.Resolve(row)
+	}
+
+	return recipients
+}
+
+// CheckRecipientColumns verifies the mapping against the columns query
+// returns, as told by the csv schema or the database. Datasets that can't
+// tell them, like dynamodb tables whose items carry whatever attributes
+// they have, are not checked.
+func CheckRecipientColumns(d *DataSet, m *RecipientMapping, query string, args []interface{}) error {
+	if m == nil {
+		return nil
+	}
+
+	columns, ok, err := QueryColumns(d, query, args)
+	if err != nil || !ok {
+		return err
+	}
+
+	return m.CheckColumns(columns)
+}
+
+// CheckQueryColumns verifies the mapping against the columns query returns
+// from a csv dataset, whose schema is stored on upload; an empty query
+// stands for all its columns. Other mappings are checked when the
+// recipients are resolved.
+func (d DataSet) CheckQueryColumns(m *RecipientMapping, query string) error {
+	if m == nil || d.Type != "csv" || len(d.Columns) == 0 {
+		return nil
+	}
+
+	columns, err := csvQueryColumns(&d, query)
+	if err != nil {
+		return err
+	}
+
+	return m.CheckColumns(columns)
+}
+
+func columnNames(columns []Column) []string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+
+	return names
+}
+
+func recipientValue(row Row, column string) string {
+	if len(column) == 0 {
+		return ""
+	}
+
+	switch v := row[column].(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case []byte:
+		return strings.TrimSpace(string(v))
+	case float64:
+		// JSON numbers, e.g. phone numbers from http or dynamodb datasets
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,68 @@
+package datasets
+
+import (
+	"crypto/subtle"
+	"errors"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/common/redact"
+	"hermes/pkg/credentials"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var (
+	RevealTokenHeader        = "X-Reveal-Token"
+	ErrorRevealNotAuthorised = "not authorised to reveal dataset credentials"
+)
+
+type RevealedCredentials struct {
+	Id          string `json:"id"`
+	Provider    string `json:"provider"`
+	Credentials string `json:"credentials"`
+	Version     string `json:"version,omitempty"`
+}
+
+// IsRevealAuthorised checks the X-Reveal-Token header against the
+// CREDENTIALS_REVEAL_TOKEN secret. Revealing is disabled when it is unset.
+func IsRevealAuthorised(req events.APIGatewayProxyRequest) bool {
+	expected := os.Getenv("CREDENTIALS_REVEAL_TOKEN")
+	if len(expected) == 0 {
+		return false
+	}
+
+	for name, value := range req.Headers {
+		if strings.EqualFold(name, RevealTokenHeader) {
+			return subtle.ConstantTimeCompare([]byte(value), []byte(expected)) == 1
+		}
+	}
+
+	return false
+}
+
+// RevealDatasetCredentials resolves the secret behind a dataset through its
+// provider. Every reveal is logged, without the secret.
+func RevealDatasetCredentials(req events.APIGatewayProxyRequest, repo crud.CrudRepository, id string) (
+	*RevealedCredentials,
+	error,
+) {
+	d, _ := FetchDataset(id, repo)
+	if d != nil && len(d.Name) == 0 {
+		return nil, errors.New(ErrorDatasetDoesNotExists)
+	}
+
+	provider, err := credentials.Get(d.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, version, err := provider.Resolve(d.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	redact.Println("credentials revealed for dataset", d.Id, "to", req.RequestContext.Identity.SourceIP)
+
+	return &RevealedCredentials{Id: d.Id, Provider: d.Provider, Credentials: secret, Version: version}, nil
+}
@@ -0,0 +1,79 @@
+package datasets
+
+import (
+	"encoding/json"
+	"errors"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/common/redact"
+	"hermes/pkg/credentials"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var (
+	ErrorInvalidRotationData   = "invalid rotation data. Expected the new credentials"
+	ErrorRotatedCredentialsBad = "new credentials failed the connection test. The dataset keeps using the previous version"
+)
+
+type RotateCredentialsRequest struct {
+	Credentials string `json:"credentials"`
+}
+
+// RotateDatasetCredentials stages the new secret, tests a connection with
+// it and only then promotes it and points the dataset at it. Until then,
+// everything reading the secret keeps getting the previous one.
+func RotateDatasetCredentials(req events.APIGatewayProxyRequest, repo crud.CrudRepository, id string) (
+	*DataSet,
+	error,
+) {
+	var r RotateCredentialsRequest
+	if err := json.Unmarshal([]byte(req.Body), &r); err != nil || len(r.Credentials) == 0 {
+		return nil, errors.New(ErrorInvalidRotationData)
+	}
+
+	d, _ := FetchDataset(id, repo)
+	if d != nil && len(d.Name) == 0 {
+		return nil, errors.New(ErrorDatasetDoesNotExists)
+	}
+
+	provider, err := credentials.Get(d.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	staged, err := provider.Stage(d.Credentials, r.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	candidate := d.Connection()
+	candidate.Credentials = staged
+	if err := testConnection(candidate); err != nil {
+		redact.Println("rotation of dataset", d.Id, "failed:", err)
+		provider.Discard(staged)
+		return nil, errors.New(ErrorRotatedCredentialsBad)
+	}
+
+	ref, err := provider.Promote(d.Credentials, staged)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := d.Credentials
+	d.Credentials = ref
+	_, err = repo.Update(d.Id, d)
+	if err != nil {
+		return nil, err
+	}
+
+	// datasets created before namespacing kept their secret under the bare
+	// id, which is left behind when the promoted one moves to SecretName
+	if previous == d.Id && !strings.HasPrefix(ref, previous) {
+		provider.Delete(previous)
+	}
+
+	Connections.Evict(d.Id)
+
+	return d, nil
+}
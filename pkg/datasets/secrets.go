@@ -0,0 +1,170 @@
+package datasets
+
+import (
+	"errors"
+	"hermes/pkg/common/redact"
+	"hermes/pkg/credentials"
+	"strings"
+)
+
+// attachedSecret is a secret kept beside the dataset credentials, such as a
+// private key. It is stored through its own provider under "{id}/{name}",
+// and ref holds the inline secret until it is replaced by the reference.
+type attachedSecret struct {
+	name     string
+	ref      *string
+	provider string
+}
+
+func attachedSecrets(d *DataSet) []attachedSecret {
+	secrets := []attachedSecret{}
+	if d.SSH != nil {
+		secrets = append(secrets, attachedSecret{name: "ssh-key", ref: &d.SSH.PrivateKey, provider: d.SSH.keyProvider(d.Provider)})
+	}
+	if d.TLS != nil {
+		secrets = append(secrets, attachedSecret{name: "tls-key", ref: &d.TLS.ClientKey, provider: d.TLS.keyProvider(d.Provider)})
+	}
+
+	return secrets
+}
+
+func (s attachedSecret) store(datasetId string) error {
+	if len(*s.ref) == 0 {
+		return nil
+	}
+
+	// the mask stands for a stored secret, e.g. one moving to another
+	// provider, which has to be sent again
+	if *s.ref == redact.Mask {
+		return errors.New(ErrorMaskedSecret)
+	}
+
+	provider, err := credentials.Get(s.provider)
+	if err != nil {
+		return err
+	}
+
+	ref, err := provider.Store(datasetId+"/"+s.name, *s.ref)
+	if err != nil {
+		return err
+	}
+	*s.ref = ref
+
+	return nil
+}
+
+func (s attachedSecret) delete() {
+	if len(*s.ref) == 0 {
+		return
+	}
+
+	if provider, err := credentials.Get(s.provider); err == nil {
+		provider.Delete(*s.ref)
+	}
+}
+
+func storeAttachedSecrets(d *DataSet) error {
+	for _, s := range attachedSecrets(d) {
+		if err := s.store(d.Id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateAttachedSecrets keeps the stored secrets an update leaves out or
+// sends back masked, stores the new ones and drops those no longer used.
+func updateAttachedSecrets(d *DataSet, current *DataSet) error {
+	previous := map[string]attachedSecret{}
+	for _, s := range attachedSecrets(current) {
+		previous[s.name] = s
+	}
+
+	for _, s := range attachedSecrets(d) {
+		old, found := previous[s.name]
+		delete(previous, s.name)
+
+		if found && old.provider == s.provider && len(*old.ref) > 0 {
+			if len(*s.ref) == 0 || *s.ref == redact.Mask {
+				*s.ref = *old.ref
+			}
+			if *s.ref == *old.ref {
+				continue
+			}
+		}
+
+		if err := s.store(d.Id); err != nil {
+			return err
+		}
+
+		if found && old.provider != s.provider {
+			old.delete()
+		}
+	}
+
+	for _, old := range previous {
+		old.delete()
+	}
+
+	return nil
+}
+
+// restoreMaskedHTTP puts back the stored http options Redacted masked, so
+// a dataset read and sent back unchanged keeps its headers, URL and body.
+// A masked value that was edited can't be told apart from a new secret.
+func restoreMaskedHTTP(d *DataSet, current *DataSet) error {
+	if d.HTTP == nil {
+		return nil
+	}
+
+	stored := current.HTTP
+	if stored == nil {
+		stored = &HTTPOptions{}
+	}
+
+	restore := func(sent *string, value string, masked string) error {
+		if !strings.Contains(*sent, redact.Mask) {
+			return nil
+		}
+		if *sent != masked {
+			return errors.New(ErrorMaskedSecret)
+		}
+		*sent = value
+		return nil
+	}
+
+	if err := restore(&d.HTTP.URL, stored.URL, redact.URL(stored.URL)); err != nil {
+		return err
+	}
+	if err := restore(&d.HTTP.Body, stored.Body, redact.String(stored.Body)); err != nil {
+		return err
+	}
+
+	masked := redact.Headers(stored.Headers)
+	for name, value := range d.HTTP.Headers {
+		if err := restore(&value, stored.Headers[name], masked[name]); err != nil {
+			return err
+		}
+		d.HTTP.Headers[name] = value
+	}
+
+	return nil
+}
+
+func deleteAttachedSecrets(d *DataSet) {
+	for _, s := range attachedSecrets(d) {
+		s.delete()
+	}
+}
+
+// resolveAttachedSecret returns the secret behind a stored reference.
+func resolveAttachedSecret(providerName string, ref string) (string, error) {
+	provider, err := credentials.Get(providerName)
+	if err != nil {
+		return "", err
+	}
+
+	secret, _, err := provider.Resolve(ref)
+	return secret, err
+}
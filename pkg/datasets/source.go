@@ -0,0 +1,96 @@
+package datasets
+
+import (
+	"crypto/tls"
+	"errors"
+	"hermes/pkg/common/redact"
+	"net"
+	"sync"
+)
+
+var (
+	ErrorUnsupportedType = "dataset type is not configured in this environment"
+)
+
+// Row is a single record returned by a dataset, keyed by column name.
+type Row map[string]interface{}
+
+// Source is an open handle to the data behind a DataSet.
+type Source interface {
+	Ping() error
+	Query(query string, args ...interface{}) ([]Row, error)
+	Close() error
+}
+
+// Dialer opens network connections for a driver, e.g. through a tunnel.
+type Dialer func(network, address string) (net.Conn, error)
+
+// Resolved is what OpenSource derives from a Connection before handing it
+// to a driver. Secret is the connection's credentials after they have been
+// resolved through its provider. Dial is nil for direct connections, and
+// TLS is nil unless the connection has TLS options enabling it.
+type Resolved struct {
+	Secret string
+	Dial   Dialer
+	TLS    *tls.Config
+}
+
+// Driver opens a Source for one dataset type.
+type Driver interface {
+	Open(c Connection, r Resolved) (Source, error)
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]Driver{}
+)
+
+// RegisterDriver makes a dataset type available to OpenSource. Drivers that
+// depend on AWS clients are registered by the lambda entrypoints.
+func RegisterDriver(dataSetType string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	drivers[dataSetType] = driver
+}
+
+func OpenSource(c Connection, secret string) (Source, error) {
+	driversMu.RLock()
+	driver, ok := drivers[c.Type]
+	driversMu.RUnlock()
+
+	if !ok {
+		redact.Println("no driver registered for", c.Type)
+		return nil, errors.New(ErrorUnsupportedType)
+	}
+
+	tlsConfig, err := buildTLSConfig(c.TLS, c.Provider)
+	if err != nil {
+		return nil, err
+	}
+	r := Resolved{Secret: secret, TLS: tlsConfig}
+
+	var tunnel *sshTunnel
+	if c.SSH != nil {
+		t, err := openSSHTunnel(c.SSH, c.Provider)
+		if err != nil {
+			return nil, err
+		}
+		tunnel = t
+		r.Dial = tunnel.Dial
+	}
+
+	source, err := driver.Open(c, r)
+	if err != nil {
+		if tunnel != nil {
+			tunnel.Close()
+		}
+		return nil, err
+	}
+
+	if tunnel != nil {
+		return &tunnelledSource{Source: source, tunnel: tunnel}, nil
+	}
+
+	return source, nil
+}
@@ -0,0 +1,224 @@
+package datasets
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hermes/pkg/common/redact"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrorCouldNotRunQuery = "could not run query against dataset"
+	ErrorDialTimeout      = "timed out connecting to the database"
+)
+
+type sqlDriver struct{}
+
+type sqlSource struct {
+	db *sql.DB
+}
+
+func init() {
+	RegisterDriver("sql", sqlDriver{})
+}
+
+func (sqlDriver) Open(c Connection, r Resolved) (Source, error) {
+	dsn := r.Secret
+	if c.TLS != nil {
+		// TLS options replace whatever the DSN asks for
+		dsn = withSSLMode(dsn, TLSModeDisable)
+	}
+
+	dial := r.Dial
+	if r.TLS != nil {
+		dial = postgresTLSDialer(dial, r.TLS)
+	}
+
+	if dial != nil {
+		return &sqlSource{db: sql.OpenDB(dialConnector{dsn: dsn, dial: dial})}, nil
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, errors.New(ErrorInvalidConnectionCredentials)
+	}
+
+	return &sqlSource{db: db}, nil
+}
+
+// dialConnector opens Postgres connections through a custom Dialer.
+type dialConnector struct {
+	dsn  string
+	dial Dialer
+}
+
+func (c dialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return pq.DialOpen(c, c.dsn)
+}
+
+func (c dialConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+func (c dialConnector) Dial(network, address string) (net.Conn, error) {
+	return c.dial(network, address)
+}
+
+// DialTimeout bounds the dial, as custom dialers such as ssh tunnels don't
+// take a timeout of their own. A connection made after the timeout is
+// closed.
+func (c dialConnector) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		return c.dial(network, address)
+	}
+
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan dialed, 1)
+	go func() {
+		conn, err := c.dial(network, address)
+		done <- dialed{conn, err}
+	}()
+
+	select {
+	case d := <-done:
+		return d.conn, d.err
+	case <-time.After(timeout):
+		go func() {
+			if d := <-done; d.conn != nil {
+				d.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("%s: %s after %v", ErrorDialTimeout, address, timeout)
+	}
+}
+
+func (s *sqlSource) Ping() error {
+	return s.db.Ping()
+}
+
+// sqlArgs binds lists as Postgres arrays, which lib/pq doesn't do for
+// slices on its own, so list inputs are written as = ANY($1).
+func sqlArgs(args []interface{}) []interface{} {
+	bound := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case []string, []int64, []float64, []bool:
+			bound[i] = pq.Array(v)
+		default:
+			bound[i] = arg
+		}
+	}
+
+	return bound
+}
+
+func (s *sqlSource) Query(query string, args ...interface{}) ([]Row, error) {
+	rows, err := s.db.Query(query, sqlArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRows(rows)
+}
+
+// Stream reads the query through a server-side cursor, so only
+// StreamFetchSize rows are held in memory at a time. The query should be
+// ordered for offsets to be stable across runs.
+func (s *sqlSource) Stream(query string, args []interface{}, offset int, fn func(Row) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	// the transaction only reads, so it is never committed
+	defer tx.Rollback()
+
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	if _, err := tx.Exec("DECLARE hermes_stream NO SCROLL CURSOR FOR "+query, sqlArgs(args)...); err != nil {
+		redact.Println(err)
+		return errors.New(ErrorCouldNotRunQuery)
+	}
+
+	if offset > 0 {
+		if _, err := tx.Exec(fmt.Sprintf("MOVE FORWARD %d IN hermes_stream", offset)); err != nil {
+			return err
+		}
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM hermes_stream", StreamFetchSize)
+	for {
+		rows, err := tx.Query(fetch)
+		if err != nil {
+			return err
+		}
+		batch, err := scanRows(rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, row := range batch {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+
+		if len(batch) < StreamFetchSize {
+			return nil
+		}
+	}
+}
+
+func (s *sqlSource) SetPool(config PoolConfig) {
+	s.db.SetMaxOpenConns(config.MaxOpenConns)
+	s.db.SetMaxIdleConns(config.MaxIdleConns)
+	s.db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	s.db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+}
+
+func (s *sqlSource) Close() error {
+	return s.db.Close()
+}
+
+func scanRows(rows *sql.Rows) ([]Row, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Row{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		row := Row{}
+		for i, column := range columns {
+			// lib/pq hands text and numeric columns back as raw bytes
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+				continue
+			}
+			row[column] = values[i]
+		}
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,42 @@
+package datasets
+
+// StreamFetchSize is the number of rows fetched from a server-side cursor
+// at a time.
+var StreamFetchSize = envInt("STREAM_FETCH_SIZE", 1000)
+
+// Streamer is implemented by sources that can hand rows over one at a time
+// instead of loading the whole result in memory. Rows before offset are
+// skipped, so a stream can be resumed. Returning an error from fn stops
+// the stream and is returned as is.
+type Streamer interface {
+	Stream(query string, args []interface{}, offset int, fn func(Row) error) error
+}
+
+// StreamRows streams the rows of query from source, falling back to a
+// regular query for sources that can't stream.
+func StreamRows(source Source, query string, args []interface{}, offset int, fn func(Row) error) error {
+	if streamer, ok := source.(Streamer); ok {
+		return streamer.Stream(query, args, offset, fn)
+	}
+
+	rows, err := source.Query(query, args...)
+	if err != nil {
+		return err
+	}
+
+	for i := offset; i < len(rows); i++ {
+		if err := fn(rows[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s pooledSource) Stream(query string, args []interface{}, offset int, fn func(Row) error) error {
+	return StreamRows(s.Source, query, args, offset, fn)
+}
+
+func (s *tunnelledSource) Stream(query string, args []interface{}, offset int, fn func(Row) error) error {
+	return StreamRows(s.Source, query, args, offset, fn)
+}
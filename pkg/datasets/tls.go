@@ -0,0 +1,233 @@
+package datasets
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"hermes/pkg/credentials"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	TLSModeDisable    = "disable"
+	TLSModeRequire    = "require"
+	TLSModeVerifyCA   = "verify-ca"
+	TLSModeVerifyFull = "verify-full"
+)
+
+var (
+	ErrorInvalidTLSOptions    = "invalid tls options"
+	ErrorInvalidTLSCACert     = "invalid tls CA certificate. Expected PEM encoded certificates"
+	ErrorInvalidTLSClientCert = "invalid tls client certificate or key"
+	ErrorServerRefusedTLS     = "server does not accept TLS connections"
+)
+
+// TLSOptions replaces the TLS settings of a DSN or URL. CACert and
+// ClientCert are PEM encoded; ClientKey is stored through Provider like
+// the dataset credentials, which is also its default provider.
+type TLSOptions struct {
+	Mode       string `json:"mode"`
+	CACert     string `json:"caCert,omitempty"`
+	ClientCert string `json:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty"`
+	ServerName string `json:"serverName,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+}
+
+// TLSError is returned when a connection fails during or after a TLS
+// handshake, with the chain the server presented.
+type TLSError struct {
+	Err  error
+	Info *TLSInfo
+}
+
+func (e *TLSError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TLSError) Unwrap() error {
+	return e.Err
+}
+
+func (o TLSOptions) keyProvider(datasetProvider string) string {
+	if len(o.Provider) > 0 {
+		return o.Provider
+	}
+
+	return datasetProvider
+}
+
+func ValidateTLSOptions(o *TLSOptions, dataSetType string, datasetProvider string) error {
+	if o == nil {
+		return nil
+	}
+
+	if dataSetType != "sql" && dataSetType != "http" {
+		return errors.New(ErrorInvalidTLSOptions + ": only sql and http datasets accept tls options")
+	}
+
+	switch o.Mode {
+	case TLSModeDisable, TLSModeRequire, TLSModeVerifyCA, TLSModeVerifyFull:
+	default:
+		return errors.New(ErrorInvalidTLSOptions + ": mode must be disable, require, verify-ca or verify-full")
+	}
+
+	if o.Mode == TLSModeDisable && dataSetType == "http" {
+		return errors.New(ErrorInvalidTLSOptions + ": use an http URL to disable tls")
+	}
+
+	if !credentials.IsRegistered(o.keyProvider(datasetProvider)) {
+		return errors.New(ErrorInvalidTLSOptions + ": " + ErrorInvalidProvider)
+	}
+
+	if len(o.CACert) > 0 && !x509.NewCertPool().AppendCertsFromPEM([]byte(o.CACert)) {
+		return errors.New(ErrorInvalidTLSCACert)
+	}
+
+	if (len(o.ClientCert) > 0) != (len(o.ClientKey) > 0) {
+		return errors.New(ErrorInvalidTLSOptions + ": clientCert and clientKey must be set together")
+	}
+
+	if strings.Contains(o.ClientKey, "PRIVATE KEY") {
+		if _, err := tls.X509KeyPair([]byte(o.ClientCert), []byte(o.ClientKey)); err != nil {
+			return errors.New(ErrorInvalidTLSClientCert)
+		}
+	}
+
+	return nil
+}
+
+// buildTLSConfig returns the client configuration for the options, or nil
+// when TLS is disabled. The client key is resolved through its provider.
+func buildTLSConfig(o *TLSOptions, datasetProvider string) (*tls.Config, error) {
+	if o == nil || o.Mode == TLSModeDisable {
+		return nil, nil
+	}
+
+	config := &tls.Config{ServerName: o.ServerName}
+
+	if len(o.CACert) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM([]byte(o.CACert)) {
+			return nil, errors.New(ErrorInvalidTLSCACert)
+		}
+	}
+
+	if len(o.ClientCert) > 0 {
+		key, err := resolveAttachedSecret(o.keyProvider(datasetProvider), o.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+
+		cert, err := tls.X509KeyPair([]byte(o.ClientCert), []byte(key))
+		if err != nil {
+			return nil, errors.New(ErrorInvalidTLSClientCert)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	// certificates are checked in VerifyConnection, so a failure can report
+	// the chain that was presented
+	mode := o.Mode
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if mode == TLSModeRequire {
+			return nil
+		}
+
+		host := state.ServerName
+		if mode == TLSModeVerifyCA {
+			host = ""
+		}
+
+		if err := verifyChain(state.PeerCertificates, config.RootCAs, host); err != nil {
+			return &TLSError{Err: err, Info: &TLSInfo{Chain: describeChain(state.PeerCertificates)}}
+		}
+
+		return nil
+	}
+
+	return config, nil
+}
+
+func verifyChain(certs []*x509.Certificate, roots *x509.CertPool, host string) error {
+	if len(certs) == 0 {
+		return errors.New("server did not present a certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{DNSName: host, Roots: roots, Intermediates: intermediates})
+
+	return err
+}
+
+func describeChain(certs []*x509.Certificate) []string {
+	chain := []string{}
+	for _, cert := range certs {
+		chain = append(chain, fmt.Sprintf("%s (issuer: %s, expires: %s)",
+			cert.Subject.String(), cert.Issuer.String(), cert.NotAfter.Format(time.RFC3339)))
+	}
+
+	return chain
+}
+
+// postgresTLSDialer negotiates TLS itself, so lib/pq is given plain
+// connections and the configuration is not limited to what a DSN holds.
+func postgresTLSDialer(dial Dialer, config *tls.Config) Dialer {
+	if dial == nil {
+		dial = func(network, address string) (net.Conn, error) {
+			return net.DialTimeout(network, address, 30*time.Second)
+		}
+	}
+
+	return func(network, address string) (net.Conn, error) {
+		conn, err := dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		accepted, err := postgresSSLRequest(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if !accepted {
+			conn.Close()
+			return nil, errors.New(ErrorServerRefusedTLS)
+		}
+
+		c := config.Clone()
+		if len(c.ServerName) == 0 {
+			c.ServerName, _, _ = net.SplitHostPort(address)
+		}
+
+		client := tls.Client(conn, c)
+		if err := client.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return client, nil
+	}
+}
+
+// withSSLMode overrides the sslmode of either form of Postgres DSN.
+func withSSLMode(dsn string, mode string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("sslmode", mode)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	// later keys take precedence in key=value DSNs
+	return fmt.Sprintf("%s sslmode=%s", dsn, mode)
+}
@@ -0,0 +1,207 @@
+package datasets
+
+import (
+	"errors"
+	"fmt"
+	"hermes/pkg/common/redact"
+	"hermes/pkg/credentials"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	SSHTunnelTimeout          = 10 * time.Second
+	ErrorInvalidSSHTunnel     = "invalid ssh tunnel options"
+	ErrorInvalidSSHPrivateKey = "invalid ssh private key"
+	ErrorInvalidSSHHostKey    = "invalid ssh host key. Expected the authorized_keys format"
+	ErrorUnableToOpenTunnel   = "unable to open ssh tunnel. check the jump host, user and keys"
+)
+
+// SSHTunnel routes the connections of a dataset through a jump host.
+// PrivateKey is stored through Provider like the dataset credentials, and
+// HostKey is the public key the jump host must present.
+type SSHTunnel struct {
+	Host       string `json:"host"`
+	User       string `json:"user"`
+	HostKey    string `json:"hostKey"`
+	PrivateKey string `json:"privateKey,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+}
+
+// address returns the jump host address, with the default ssh port when
+// none is given.
+func (t SSHTunnel) address() string {
+	if _, _, err := net.SplitHostPort(t.Host); err == nil {
+		return t.Host
+	}
+
+	return net.JoinHostPort(t.Host, "22")
+}
+
+// keyProvider is the provider the private key is stored with, which
+// defaults to the provider of the dataset.
+func (t SSHTunnel) keyProvider(datasetProvider string) string {
+	if len(t.Provider) > 0 {
+		return t.Provider
+	}
+
+	return datasetProvider
+}
+
+// ValidateSSHTunnel checks the tunnel options. The private key is only
+// parsed when it is inline, as a stored reference can't be read here.
+func ValidateSSHTunnel(t *SSHTunnel, datasetProvider string) error {
+	if t == nil {
+		return nil
+	}
+
+	if len(t.Host) == 0 || len(t.User) == 0 {
+		return errors.New(ErrorInvalidSSHTunnel + ": host and user are required")
+	}
+
+	if !credentials.IsRegistered(t.keyProvider(datasetProvider)) {
+		return errors.New(ErrorInvalidSSHTunnel + ": " + ErrorInvalidProvider)
+	}
+
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(t.HostKey)); err != nil {
+		return errors.New(ErrorInvalidSSHHostKey)
+	}
+
+	if strings.Contains(t.PrivateKey, "PRIVATE KEY") {
+		if _, err := ssh.ParsePrivateKey([]byte(t.PrivateKey)); err != nil {
+			return errors.New(ErrorInvalidSSHPrivateKey)
+		}
+	}
+
+	return nil
+}
+
+// sshTunnel keeps one ssh client to the jump host and reconnects it when
+// it has been dropped, since pooled sources outlive idle ssh sessions.
+type sshTunnel struct {
+	mu     sync.Mutex
+	config *ssh.ClientConfig
+	addr   string
+	client *ssh.Client
+}
+
+func openSSHTunnel(t *SSHTunnel, datasetProvider string) (*sshTunnel, error) {
+	key, err := resolveAttachedSecret(t.keyProvider(datasetProvider), t.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey([]byte(key))
+	if err != nil {
+		return nil, errors.New(ErrorInvalidSSHPrivateKey)
+	}
+
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(t.HostKey))
+	if err != nil {
+		return nil, errors.New(ErrorInvalidSSHHostKey)
+	}
+
+	tunnel := &sshTunnel{
+		addr: t.address(),
+		config: &ssh.ClientConfig{
+			User:            t.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.FixedHostKey(hostKey),
+			Timeout:         SSHTunnelTimeout,
+		},
+	}
+
+	if _, err := tunnel.connect(); err != nil {
+		return nil, err
+	}
+
+	return tunnel, nil
+}
+
+func (t *sshTunnel) connect() (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client != nil {
+		return t.client, nil
+	}
+
+	client, err := ssh.Dial("tcp", t.addr, t.config)
+	if err != nil {
+		redact.Println("could not connect to ssh jump host", t.addr, err)
+		return nil, fmt.Errorf("%s: %v", ErrorUnableToOpenTunnel, err)
+	}
+	t.client = client
+
+	return client, nil
+}
+
+// Dial opens a connection to address from the jump host.
+func (t *sshTunnel) Dial(network, address string) (net.Conn, error) {
+	client, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.Dial(network, address)
+	if err == nil {
+		return conn, nil
+	}
+
+	// the session may have been dropped by the jump host; retry once on a
+	// fresh one
+	t.reset(client)
+	if client, err = t.connect(); err != nil {
+		return nil, err
+	}
+
+	return client.Dial(network, address)
+}
+
+func (t *sshTunnel) reset(client *ssh.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client == client {
+		t.client.Close()
+		t.client = nil
+	}
+}
+
+func (t *sshTunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client == nil {
+		return nil
+	}
+
+	err := t.client.Close()
+	t.client = nil
+
+	return err
+}
+
+// tunnelledSource closes the tunnel along with the source it serves.
+type tunnelledSource struct {
+	Source
+	tunnel *sshTunnel
+}
+
+func (s *tunnelledSource) Close() error {
+	err := s.Source.Close()
+	s.tunnel.Close()
+
+	return err
+}
+
+// SetPool forwards pool settings to the tunnelled source.
+func (s *tunnelledSource) SetPool(config PoolConfig) {
+	if p, ok := s.Source.(Pooler); ok {
+		p.SetPool(config)
+	}
+}
@@ -0,0 +1,343 @@
+package datasets
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// jumpHost is a local ssh server that only forwards direct-tcpip channels,
+// the way a bastion host is used by dataset tunnels.
+type jumpHost struct {
+	addr    string
+	hostKey string
+
+	listener net.Listener
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+func newSigner(t *testing.T) (ssh.Signer, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func startJumpHost(t *testing.T, user string, clientKey ssh.PublicKey) *jumpHost {
+	t.Helper()
+
+	hostSigner, _ := newSigner(t)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == user && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := &jumpHost{
+		addr:     listener.Addr().String(),
+		hostKey:  string(ssh.MarshalAuthorizedKey(hostSigner.PublicKey())),
+		listener: listener,
+	}
+	t.Cleanup(host.close)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			host.mu.Lock()
+			host.conns = append(host.conns, conn)
+			host.mu.Unlock()
+			go host.serve(conn, config)
+		}
+	}()
+
+	return host
+}
+
+func (h *jumpHost) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "direct-tcpip" {
+			newChannel.Reject(ssh.UnknownChannelType, "only direct-tcpip is supported")
+			continue
+		}
+
+		var target struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+
+		upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			upstream.Close()
+			continue
+		}
+		go ssh.DiscardRequests(channelRequests)
+		go func() {
+			io.Copy(channel, upstream)
+			channel.Close()
+		}()
+		go func() {
+			io.Copy(upstream, channel)
+			upstream.Close()
+		}()
+	}
+}
+
+// dropSessions closes the ssh connections accepted so far, as a jump host
+// dropping idle sessions would.
+func (h *jumpHost) dropSessions() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, conn := range h.conns {
+		conn.Close()
+	}
+	h.conns = nil
+}
+
+func (h *jumpHost) close() {
+	h.listener.Close()
+	h.dropSessions()
+}
+
+// startEchoServer stands for a database only reachable from the jump host.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func assertEcho(t *testing.T, conn net.Conn, message string) {
+	t.Helper()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(message)); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := make([]byte, len(message))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != message {
+		t.Fatalf("expected %q through the tunnel, got %q", message, reply)
+	}
+}
+
+func TestSSHTunnelDialsThroughJumpHost(t *testing.T) {
+	clientSigner, clientKey := newSigner(t)
+	host := startJumpHost(t, "hermes", clientSigner.PublicKey())
+	database := startEchoServer(t)
+
+	tunnel, err := openSSHTunnel(&SSHTunnel{Host: host.addr, User: "hermes", HostKey: host.hostKey, PrivateKey: string(clientKey)}, "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	conn, err := tunnel.Dial("tcp", database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	assertEcho(t, conn, "select 1")
+}
+
+func TestSSHTunnelReconnectsDroppedSessions(t *testing.T) {
+	clientSigner, clientKey := newSigner(t)
+	host := startJumpHost(t, "hermes", clientSigner.PublicKey())
+	database := startEchoServer(t)
+
+	tunnel, err := openSSHTunnel(&SSHTunnel{Host: host.addr, User: "hermes", HostKey: host.hostKey, PrivateKey: string(clientKey)}, "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	host.dropSessions()
+	// let the client notice the session is gone
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := tunnel.Dial("tcp", database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	assertEcho(t, conn, "after reconnect")
+}
+
+func TestSSHTunnelRejectsUnexpectedHostKey(t *testing.T) {
+	clientSigner, clientKey := newSigner(t)
+	host := startJumpHost(t, "hermes", clientSigner.PublicKey())
+	otherHost, _ := newSigner(t)
+
+	_, err := openSSHTunnel(&SSHTunnel{
+		Host:       host.addr,
+		User:       "hermes",
+		HostKey:    string(ssh.MarshalAuthorizedKey(otherHost.PublicKey())),
+		PrivateKey: string(clientKey),
+	}, "none")
+	if err == nil {
+		t.Fatal("expected the tunnel to refuse a jump host presenting another key")
+	}
+}
+
+func TestSSHTunnelRejectsUnknownClientKey(t *testing.T) {
+	clientSigner, _ := newSigner(t)
+	host := startJumpHost(t, "hermes", clientSigner.PublicKey())
+	_, otherKey := newSigner(t)
+
+	_, err := openSSHTunnel(&SSHTunnel{Host: host.addr, User: "hermes", HostKey: host.hostKey, PrivateKey: string(otherKey)}, "none")
+	if err == nil {
+		t.Fatal("expected the jump host to refuse an unknown client key")
+	}
+}
+
+// tunnelProbeDriver opens sources that connect through the dialer they
+// are given, to check OpenSource wires the tunnel in.
+type tunnelProbeDriver struct {
+	target string
+}
+
+type tunnelProbeSource struct {
+	conn net.Conn
+}
+
+func (d tunnelProbeDriver) Open(c Connection, r Resolved) (Source, error) {
+	conn, err := r.Dial("tcp", d.target)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tunnelProbeSource{conn: conn}, nil
+}
+
+func (s *tunnelProbeSource) Ping() error {
+	_, err := s.conn.Write([]byte("ping"))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.ReadFull(s.conn, make([]byte, 4))
+	return err
+}
+
+func (s *tunnelProbeSource) Query(query string, args ...interface{}) ([]Row, error) {
+	return nil, nil
+}
+
+func (s *tunnelProbeSource) Close() error {
+	return s.conn.Close()
+}
+
+func TestOpenSourceTunnelsDatasetConnections(t *testing.T) {
+	clientSigner, clientKey := newSigner(t)
+	host := startJumpHost(t, "hermes", clientSigner.PublicKey())
+	RegisterDriver("tunnel-probe", tunnelProbeDriver{target: startEchoServer(t)})
+
+	source, err := OpenSource(Connection{
+		Type:     "tunnel-probe",
+		Provider: "none",
+		SSH:      &SSHTunnel{Host: host.addr, User: "hermes", HostKey: host.hostKey, PrivateKey: string(clientKey)},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	if err := source.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDialConnectorHonoursTimeout(t *testing.T) {
+	blocked := make(chan struct{})
+	defer close(blocked)
+
+	connector := dialConnector{dial: func(network, address string) (net.Conn, error) {
+		<-blocked
+		return nil, io.EOF
+	}}
+
+	start := time.Now()
+	if _, err := connector.DialTimeout("tcp", "10.0.0.1:5432", 50*time.Millisecond); err == nil {
+		t.Fatal("expected the dial to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dial took %v despite a 50ms timeout", elapsed)
+	}
+}
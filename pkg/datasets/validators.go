@@ -1,13 +1,66 @@
 package datasets
 
+import (
+	"errors"
+	"hermes/pkg/credentials"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrorInvalidDatasetId = "invalid dataset id. Use up to 128 letters, digits, dots, dashes and underscores"
+
+	datasetIdPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+)
+
+// ValidateDatasetId keeps ids usable as the last part of a secret name:
+// they can't hold a "/" or "..", which would name a secret outside the
+// namespace of the dataset.
+func ValidateDatasetId(id string) error {
+	if !datasetIdPattern.MatchString(id) || strings.Contains(id, "..") {
+		return errors.New(ErrorInvalidDatasetId)
+	}
+
+	return nil
+}
+
 func IsProviderValid(provider string) bool {
-	if provider == "ssm" || provider == "none" {
+	return credentials.IsRegistered(provider)
+}
+
+func IsTypeValid(dataSetType string) bool {
+	switch dataSetType {
+	case "sql", "csv", "http", "dynamodb":
 		return true
 	}
 
 	return false
 }
 
-func IsTypeValid(dataSetType string) bool {
-	return dataSetType == "sql"
+// ValidateOptions checks the type specific options of a dataset.
+func ValidateOptions(d *DataSet) error {
+	if err := ValidateSSHTunnel(d.SSH, d.Provider); err != nil {
+		return err
+	}
+
+	if err := ValidateTLSOptions(d.TLS, d.Type, d.Provider); err != nil {
+		return err
+	}
+
+	if err := ValidateRecipientMapping(d.Recipients); err != nil {
+		return err
+	}
+
+	if err := d.CheckQueryColumns(d.Recipients, ""); err != nil {
+		return err
+	}
+
+	switch d.Type {
+	case "http":
+		return ValidateHTTPOptions(d.HTTP)
+	case "dynamodb":
+		return ValidateDynamoDBOptions(d.DynamoDB)
+	}
+
+	return nil
 }
@@ -0,0 +1,159 @@
+package delivery
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"hermes/pkg/common/redact"
+	"hermes/pkg/notifications"
+	"hermes/pkg/rendering"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ChannelEmail = notifications.ChannelEmail
+	ChannelSMS   = notifications.ChannelSMS
+	ChannelPush  = notifications.ChannelPush
+)
+
+var (
+	ErrorUnknownChannel     = "invalid channel. Only email, sms and push are supported"
+	ErrorChannelUnavailable = "channel is not configured"
+	ErrorInvalidAddress     = "invalid address for the channel"
+	ErrorMissingContent     = "message has no content for the channel"
+	ErrorCouldNotDeliver    = "could not deliver message"
+
+	phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+)
+
+// Message is rendered content addressed to one recipient on one channel.
+// Test messages are delivered like any other, but are marked as tests
+// wherever they are logged.
+type Message struct {
+	Id             string
+	NotificationId string
+	CampaignId     string
+	Channel        string
+	To             string
+	Test           bool
+	Rendered       *rendering.Rendered
+}
+
+// Sender delivers messages on a channel and returns the id the provider
+// gave the message.
+type Sender interface {
+	Send(m Message) (string, error)
+}
+
+var (
+	sendersMu sync.RWMutex
+	senders   = map[string]Sender{}
+)
+
+// Register makes a sender available for a channel. Senders depend on AWS
+// clients, so they are registered by the lambda entrypoints.
+func Register(channel string, sender Sender) {
+	sendersMu.Lock()
+	defer sendersMu.Unlock()
+
+	senders[channel] = sender
+}
+
+func Get(channel string) (Sender, error) {
+	if err := ValidateChannel(channel); err != nil {
+		return nil, err
+	}
+
+	sendersMu.RLock()
+	defer sendersMu.RUnlock()
+
+	sender, ok := senders[channel]
+	if !ok {
+		return nil, errors.New(ErrorChannelUnavailable)
+	}
+
+	return sender, nil
+}
+
+func ValidateChannel(channel string) error {
+	switch channel {
+	case ChannelEmail, ChannelSMS, ChannelPush:
+		return nil
+	}
+
+	return errors.New(ErrorUnknownChannel)
+}
+
+// ValidateAddress checks an address against its channel: emails need an
+// @, phone numbers are E.164 and device tokens can't be empty.
+func ValidateAddress(channel string, to string) error {
+	if err := ValidateChannel(channel); err != nil {
+		return err
+	}
+
+	to = strings.TrimSpace(to)
+	valid := len(to) > 0
+	switch channel {
+	case ChannelEmail:
+		at := strings.LastIndex(to, "@")
+		valid = at > 0 && at < len(to)-1 && !strings.ContainsAny(to, " \t\r\n")
+	case ChannelSMS:
+		valid = phonePattern.MatchString(to)
+	}
+
+	if !valid {
+		return errors.New(ErrorInvalidAddress + ": " + channel)
+	}
+
+	return nil
+}
+
+// Deliver sends a message through the sender of its channel and logs the
+// outcome, whether it was sent or not.
+func Deliver(m Message, messages *MessageRepository) (*MessageLog, error) {
+	if len(m.Id) == 0 {
+		m.Id = NewMessageId(time.Now())
+	}
+
+	entry := MessageLog{
+		Id:             m.Id,
+		NotificationId: m.NotificationId,
+		CampaignId:     m.CampaignId,
+		Channel:        m.Channel,
+		Recipient:      m.To,
+		Test:           m.Test,
+		Status:         StatusSent,
+		SentAt:         time.Now().UTC().Format(time.RFC3339Nano),
+		ExpiresAt:      time.Now().Add(messageRetention()).Unix(),
+	}
+
+	sender, err := Get(m.Channel)
+	if err == nil {
+		entry.ProviderMessageId, err = sender.Send(m)
+	}
+	if err != nil {
+		entry.Status = StatusFailed
+		entry.Error = err.Error()
+	}
+
+	redact.Println("message", entry.Id, entry.Status, "notification", entry.NotificationId, "channel", entry.Channel, "test", entry.Test)
+	if recordErr := messages.Record(entry); recordErr != nil {
+		redact.Println("could not log message", entry.Id, recordErr)
+	}
+
+	if err != nil {
+		return &entry, err
+	}
+	return &entry, nil
+}
+
+// NewMessageId sorts by creation time.
+func NewMessageId(now time.Time) string {
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
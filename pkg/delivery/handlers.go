@@ -0,0 +1,133 @@
+package delivery
+
+import (
+	"encoding/json"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/common/redact"
+	"hermes/pkg/handlers"
+	"hermes/pkg/notifications"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type ErrorBody struct {
+	ErrorMsg *string `json:"error,omitempty"`
+}
+
+func TestSendNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository, layoutRepo crud.CrudRepository, linkRepo crud.CrudRepository, messages *MessageRepository, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := SendTest(req, repo, layoutRepo, linkRepo, messages, id)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, result)
+}
+
+// SendNotification sends a notification to the recipients of the request.
+// A request carrying an Idempotency-Key is sent once; retries with the
+// same key and content get the response of the first one, or send it
+// again when the first one died before its response was stored. A
+// response that could not be stored is marked with Idempotency-Stored.
+func SendNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository, datasetRepo crud.CrudRepository, layoutRepo crud.CrudRepository, linkRepo crud.CrudRepository, messages *MessageRepository, keys *IdempotencyRepository, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	r, err := ParseSendRequest(req.Body)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+
+	key, err := IdempotencyKey(req.Headers)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+
+	now := time.Now()
+	record := &IdempotencyRecord{
+		Key:         id + "/" + key,
+		RequestHash: r.Hash(),
+		Status:      IdempotencyPending,
+		CreatedAt:   now.UTC().Format(time.RFC3339),
+		ExpiresAt:   now.Add(idempotencyTTL()).Unix(),
+	}
+	if len(key) > 0 {
+		previous, err := keys.Get(record.Key)
+		if err == nil && !previous.Expired(now) && !(previous.Abandoned(now) && previous.RequestHash == record.RequestHash) {
+			return replay(previous, record)
+		}
+	}
+
+	n, _ := notifications.FetchNotification(id, repo)
+	if n != nil && len(n.Name) == 0 {
+		return handlers.ApiResponse(http.StatusNotFound, ErrorBody{
+			aws.String(notifications.ErrorNotificationDoesNotExists),
+		})
+	}
+
+	prepared, err := PrepareSend(r, n, datasetRepo, layoutRepo, linkRepo)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+
+	if len(key) > 0 {
+		if err := keys.Claim(record, now); err != nil {
+			status := http.StatusInternalServerError
+			if err.Error() == ErrorIdempotencyInProgress {
+				status = http.StatusConflict
+			}
+			return handlers.ApiResponse(status, ErrorBody{
+				aws.String(err.Error()),
+			})
+		}
+	}
+
+	result := SendMessages(prepared, messages)
+
+	if len(key) > 0 {
+		response, _ := json.Marshal(result)
+		record.Status = IdempotencyDone
+		record.StatusCode = http.StatusOK
+		record.Response = string(response)
+		record.LockedUntil = 0
+		if err := keys.Put(record); err != nil {
+			redact.Println("could not store idempotent response", record.Key, err)
+			resp, err := handlers.ApiResponse(http.StatusOK, result)
+			resp.Headers[IdempotencyStoredHeader] = "false"
+			return resp, err
+		}
+	}
+
+	return handlers.ApiResponse(http.StatusOK, result)
+}
+
+// replay answers a retry with the stored response of the request that
+// first used its key.
+func replay(previous *IdempotencyRecord, record *IdempotencyRecord) (*events.APIGatewayProxyResponse, error) {
+	if previous.RequestHash != record.RequestHash {
+		return handlers.ApiResponse(http.StatusUnprocessableEntity, ErrorBody{
+			aws.String(ErrorIdempotencyKeyReused),
+		})
+	}
+	if previous.Status != IdempotencyDone {
+		return handlers.ApiResponse(http.StatusConflict, ErrorBody{
+			aws.String(ErrorIdempotencyInProgress),
+		})
+	}
+
+	resp, err := handlers.ApiResponse(previous.StatusCode, json.RawMessage(previous.Response))
+	resp.Headers["Idempotent-Replayed"] = "true"
+	return resp, err
+}
@@ -0,0 +1,191 @@
+package delivery
+
+import (
+	"errors"
+	BaseErrors "hermes/pkg/common/errors"
+	"hermes/pkg/common/redact"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	IdempotencyHeader  = "Idempotency-Key"
+	IdempotencyPending = "pending"
+	IdempotencyDone    = "done"
+
+	// IdempotencyStoredHeader is set to false on a response whose record
+	// could not be stored, which a retry would then send again.
+	IdempotencyStoredHeader = "Idempotency-Stored"
+)
+
+var (
+	DefaultIdempotencyTTL      = 24 * time.Hour
+	DefaultIdempotencyLease    = 2 * time.Minute
+	MaxIdempotencyKeyLength    = 255
+	ErrorInvalidIdempotencyKey = "invalid Idempotency-Key header"
+	ErrorIdempotencyKeyReused  = "Idempotency-Key was already used for a different request"
+	ErrorIdempotencyInProgress = "a request with this Idempotency-Key is in progress"
+	ErrorIdempotencyNotFound   = "idempotency key does not exist"
+)
+
+// IdempotencyRecord remembers a send made with an Idempotency-Key, so a
+// retry of the same request returns its response instead of sending again.
+// Keys are scoped to the notification. A record is pending from the moment
+// messages start going out until its response is stored, and expires
+// through the table TTL on expiresAt. A pending record is only locked
+// until lockedUntil, so a retry can take over a request that died before
+// storing its response.
+type IdempotencyRecord struct {
+	Key         string `json:"key"`
+	RequestHash string `json:"requestHash"`
+	Status      string `json:"status"`
+	StatusCode  int    `json:"statusCode,omitempty"`
+	Response    string `json:"response,omitempty"`
+	CreatedAt   string `json:"createdAt"`
+	ExpiresAt   int64  `json:"expiresAt"`
+	LockedUntil int64  `json:"lockedUntil,omitempty"`
+}
+
+func (r IdempotencyRecord) Expired(now time.Time) bool {
+	return now.Unix() >= r.ExpiresAt
+}
+
+// Abandoned tells whether a pending record outlived its lock.
+func (r IdempotencyRecord) Abandoned(now time.Time) bool {
+	return r.Status == IdempotencyPending && now.Unix() >= r.LockedUntil
+}
+
+// IdempotencyRepository stores idempotency records in a table keyed by key.
+type IdempotencyRepository struct {
+	dynaClient dynamodbiface.DynamoDBAPI
+	tableName  string
+}
+
+func InitIdempotencyRepo(t string, d dynamodbiface.DynamoDBAPI) *IdempotencyRepository {
+	return &IdempotencyRepository{
+		dynaClient: d,
+		tableName:  t,
+	}
+}
+
+func (r *IdempotencyRepository) Get(key string) (*IdempotencyRecord, error) {
+	result, err := r.dynaClient.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(key)},
+		},
+	})
+	if err != nil {
+		redact.Println(err)
+		return nil, errors.New(BaseErrors.ErrorFailedToFetchRecord)
+	}
+
+	if len(result.Item) == 0 {
+		return nil, errors.New(ErrorIdempotencyNotFound)
+	}
+
+	record := &IdempotencyRecord{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, record); err != nil {
+		return nil, errors.New(BaseErrors.ErrorFailedToUnmarshalRecord)
+	}
+
+	return record, nil
+}
+
+// Claim stores a pending record unless a live one holds the key already,
+// which keeps concurrent retries from both sending. A pending record of
+// the same request whose lock has run out is taken over.
+func (r *IdempotencyRepository) Claim(record *IdempotencyRecord, now time.Time) error {
+	record.LockedUntil = now.Add(idempotencyLease()).Unix()
+	av, err := dynamodbattribute.MarshalMap(record)
+	if err != nil {
+		return errors.New(BaseErrors.ErrorCouldNotMarshalItem)
+	}
+
+	_, err = r.dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(r.tableName),
+		ConditionExpression: aws.String("attribute_not_exists(#key) OR expiresAt <= :now OR (#status = :pending AND requestHash = :hash AND lockedUntil <= :now)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key":    aws.String("key"),
+			"#status": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":     {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+			":pending": {S: aws.String(IdempotencyPending)},
+			":hash":    {S: aws.String(record.RequestHash)},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return errors.New(ErrorIdempotencyInProgress)
+		}
+		redact.Println(err)
+		return errors.New(BaseErrors.ErrorCouldNotDynamoPutItem)
+	}
+
+	return nil
+}
+
+func (r *IdempotencyRepository) Put(record *IdempotencyRecord) error {
+	av, err := dynamodbattribute.MarshalMap(record)
+	if err != nil {
+		return errors.New(BaseErrors.ErrorCouldNotMarshalItem)
+	}
+
+	_, err = r.dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(r.tableName),
+	})
+	if err != nil {
+		redact.Println(err)
+		return errors.New(BaseErrors.ErrorCouldNotDynamoPutItem)
+	}
+
+	return nil
+}
+
+// IdempotencyKey reads the Idempotency-Key header, whatever its case. An
+// empty key means the request has none.
+func IdempotencyKey(headers map[string]string) (string, error) {
+	for name, value := range headers {
+		if !strings.EqualFold(name, IdempotencyHeader) {
+			continue
+		}
+
+		key := strings.TrimSpace(value)
+		if len(key) == 0 || len(key) > MaxIdempotencyKeyLength {
+			return "", errors.New(ErrorInvalidIdempotencyKey)
+		}
+		return key, nil
+	}
+
+	return "", nil
+}
+
+// idempotencyLease reads IDEMPOTENCY_LEASE, in Go duration syntax. It
+// should outlast the lambda timeout.
+func idempotencyLease() time.Duration {
+	if v, err := time.ParseDuration(strings.TrimSpace(os.Getenv("IDEMPOTENCY_LEASE"))); err == nil && v > 0 {
+		return v
+	}
+
+	return DefaultIdempotencyLease
+}
+
+// idempotencyTTL reads IDEMPOTENCY_TTL, in Go duration syntax.
+func idempotencyTTL() time.Duration {
+	if v, err := time.ParseDuration(strings.TrimSpace(os.Getenv("IDEMPOTENCY_TTL"))); err == nil && v > 0 {
+		return v
+	}
+
+	return DefaultIdempotencyTTL
+}
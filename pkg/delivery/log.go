@@ -0,0 +1,82 @@
+package delivery
+
+import (
+	"errors"
+	BaseErrors "hermes/pkg/common/errors"
+	"hermes/pkg/common/redact"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	StatusSent   = "sent"
+	StatusFailed = "failed"
+)
+
+var (
+	DefaultMessageRetentionDays = 90
+)
+
+// MessageLog is the outcome of delivering a message. Test is set for test
+// sends, which stats are expected to leave out.
+type MessageLog struct {
+	Id                string `json:"id"`
+	NotificationId    string `json:"notificationId"`
+	CampaignId        string `json:"campaignId,omitempty"`
+	Channel           string `json:"channel"`
+	Recipient         string `json:"recipient"`
+	Test              bool   `json:"test"`
+	Status            string `json:"status"`
+	Error             string `json:"error,omitempty"`
+	ProviderMessageId string `json:"providerMessageId,omitempty"`
+	SentAt            string `json:"sentAt"`
+	ExpiresAt         int64  `json:"expiresAt,omitempty"`
+}
+
+// MessageRepository stores the message log in a table keyed by id. Entries
+// expire through the table TTL on expiresAt.
+type MessageRepository struct {
+	dynaClient dynamodbiface.DynamoDBAPI
+	tableName  string
+}
+
+func InitMessageRepo(t string, d dynamodbiface.DynamoDBAPI) *MessageRepository {
+	return &MessageRepository{
+		dynaClient: d,
+		tableName:  t,
+	}
+}
+
+func (r *MessageRepository) Record(entry MessageLog) error {
+	av, err := dynamodbattribute.MarshalMap(entry)
+	if err != nil {
+		return errors.New(BaseErrors.ErrorCouldNotMarshalItem)
+	}
+
+	_, err = r.dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(r.tableName),
+	})
+	if err != nil {
+		redact.Println(err)
+		return errors.New(BaseErrors.ErrorCouldNotDynamoPutItem)
+	}
+
+	return nil
+}
+
+// messageRetention reads MESSAGE_RETENTION_DAYS.
+func messageRetention() time.Duration {
+	days := DefaultMessageRetentionDays
+	if v, err := strconv.Atoi(os.Getenv("MESSAGE_RETENTION_DAYS")); err == nil && v > 0 {
+		days = v
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
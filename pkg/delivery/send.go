@@ -0,0 +1,254 @@
+package delivery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hermes/pkg/audiences"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/datasets"
+	"hermes/pkg/layouts"
+	"hermes/pkg/links"
+	"hermes/pkg/notifications"
+	"hermes/pkg/rendering"
+	"strings"
+	"time"
+)
+
+var (
+	MaxSendRecipients       = 100
+	ErrorInvalidSendData    = "invalid send request"
+	ErrorNoRecipients       = "send request has no recipients"
+	ErrorTooManyRecipients  = "send request has too many recipients"
+	ErrorNotInAudience      = "recipient is not in the audience of the notification"
+	ErrorNoRecipientMapping = "the notification has no recipient mapping to look recipients up with"
+)
+
+// SendRequest triggers a notification for one recipient or a list of
+// them. Recipients that come with their data are rendered with it; the
+// others are looked up by address in the audience the notification query
+// yields for the inputs.
+type SendRequest struct {
+	Recipient  *SendRecipient         `json:"recipient,omitempty"`
+	Recipients []SendRecipient        `json:"recipients,omitempty"`
+	Inputs     map[string]interface{} `json:"inputs,omitempty"`
+}
+
+type SendRecipient struct {
+	Channel string                 `json:"channel"`
+	To      string                 `json:"to"`
+	Locale  string                 `json:"locale,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// SendResult holds a log entry for each recipient, in request order. Its
+// id is the message id; recipients that could not be reached are marked
+// as failed.
+type SendResult struct {
+	Messages []MessageLog `json:"messages"`
+}
+
+func (r SendRequest) recipients() []SendRecipient {
+	if r.Recipient == nil {
+		return r.Recipients
+	}
+
+	return append([]SendRecipient{*r.Recipient}, r.Recipients...)
+}
+
+// Hash identifies the content of the request, so a key reused for another
+// request can be told apart from a retry.
+func (r SendRequest) Hash() string {
+	content, _ := json.Marshal(r)
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func ParseSendRequest(body string) (*SendRequest, error) {
+	var r SendRequest
+	if err := json.Unmarshal([]byte(body), &r); err != nil {
+		return nil, errors.New(ErrorInvalidSendData)
+	}
+
+	return &r, nil
+}
+
+// PrepareSend checks every recipient and renders their messages before any
+// of them is sent, so that a request is either rejected or sent whole.
+func PrepareSend(r *SendRequest, n *notifications.Notification, datasetRepo crud.CrudRepository, layoutRepo crud.CrudRepository, linkRepo crud.CrudRepository) (
+	[]Message,
+	error,
+) {
+	recipients := r.recipients()
+	if len(recipients) == 0 {
+		return nil, errors.New(ErrorNoRecipients)
+	}
+	if len(recipients) > MaxSendRecipients {
+		return nil, fmt.Errorf("%s (%d at most)", ErrorTooManyRecipients, MaxSendRecipients)
+	}
+
+	for i, recipient := range recipients {
+		if err := ValidateAddress(recipient.Channel, recipient.To); err != nil {
+			return nil, fmt.Errorf("recipients[%d]: %v", i, err)
+		}
+	}
+
+	inputs, err := n.Inputs.Resolve(r.Inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	var audience map[string][]datasets.Recipient
+	if needsAudience(n, recipients) {
+		audience, err = lookupRecipients(n, r.Inputs, recipients, datasetRepo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	opts := rendering.Options{
+		Layouts: layouts.NewLibrary(layoutRepo),
+		Links:   links.NewTrackerFromEnv(linkRepo),
+	}
+
+	now := time.Now()
+	messages := make([]Message, len(recipients))
+	for i, recipient := range recipients {
+		data := rendering.Data{Row: recipient.Data, Inputs: inputs, Locale: recipient.Locale}
+		if data.Row == nil {
+			data.Row = map[string]interface{}{}
+			if audience != nil {
+				match, ok := findRecipient(audience[recipient.Channel], recipient)
+				if !ok {
+					return nil, fmt.Errorf("recipients[%d]: %s", i, ErrorNotInAudience)
+				}
+				data.Row = match.Data
+				if len(data.Locale) == 0 {
+					data.Locale = match.Locale
+				}
+			}
+		}
+
+		opts.Context = links.Context{NotificationId: n.Id, Recipient: recipient.To}
+		rendered, err := renderMessage(n, recipient.Channel, data, opts)
+		if err != nil {
+			return nil, fmt.Errorf("recipients[%d]: %v", i, err)
+		}
+
+		messages[i] = Message{
+			Id:             NewMessageId(now),
+			NotificationId: n.Id,
+			Channel:        recipient.Channel,
+			To:             strings.TrimSpace(recipient.To),
+			Rendered:       rendered,
+		}
+	}
+
+	return messages, nil
+}
+
+// SendMessages delivers each message. A message that could not be
+// delivered doesn't stop the others, it is reported as failed.
+func SendMessages(messages []Message, repo *MessageRepository) *SendResult {
+	result := &SendResult{Messages: make([]MessageLog, len(messages))}
+	for i, m := range messages {
+		entry, _ := Deliver(m, repo)
+		result.Messages[i] = *entry
+	}
+
+	return result
+}
+
+func renderMessage(n *notifications.Notification, channel string, data rendering.Data, opts rendering.Options) (*rendering.Rendered, error) {
+	index, ok := n.SelectTemplate(data.Row, data.Inputs)
+	if !ok {
+		return nil, errors.New(rendering.ErrorNoTemplateMatches)
+	}
+
+	rendered, err := rendering.RenderContent(n.Templates[index], channel, data, opts)
+	if err != nil {
+		return nil, fmt.Errorf("template %d: %v", index, err)
+	}
+
+	return rendered, nil
+}
+
+// needsAudience tells whether a recipient has to be looked up. Without a
+// query there is nothing to look up, and recipients get no row data.
+func needsAudience(n *notifications.Notification, recipients []SendRecipient) bool {
+	if len(n.Query.DataSetId) == 0 || len(n.Query.Query) == 0 {
+		return false
+	}
+
+	for _, recipient := range recipients {
+		if recipient.Data == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookupRecipients finds the recipients sent without data in the audience
+// of the notification, by the mapped field of their channel, and returns
+// them by channel.
+func lookupRecipients(n *notifications.Notification, inputs map[string]interface{}, recipients []SendRecipient, datasetRepo crud.CrudRepository) (
+	map[string][]datasets.Recipient,
+	error,
+) {
+	addresses := map[string][]string{}
+	for _, recipient := range recipients {
+		if recipient.Data == nil {
+			addresses[recipient.Channel] = append(addresses[recipient.Channel], strings.TrimSpace(recipient.To))
+		}
+	}
+
+	found := make(map[string][]datasets.Recipient, len(addresses))
+	for channel, values := range addresses {
+		matches, err := audiences.FindRecipients(n, inputs, recipientFields[channel], values, datasetRepo)
+		if err != nil {
+			return nil, err
+		}
+		if matches == nil {
+			return nil, errors.New(ErrorNoRecipientMapping)
+		}
+		found[channel] = matches
+	}
+
+	return found, nil
+}
+
+// recipientFields are the recipient fields addresses of each channel are
+// looked up by.
+var recipientFields = map[string]string{
+	ChannelEmail: "email",
+	ChannelSMS:   "phone",
+	ChannelPush:  "deviceToken",
+}
+
+// findRecipient matches the address of a recipient against the mapped
+// field of its channel.
+func findRecipient(audience []datasets.Recipient, recipient SendRecipient) (datasets.Recipient, bool) {
+	to := strings.TrimSpace(recipient.To)
+	for _, candidate := range audience {
+		switch recipient.Channel {
+		case ChannelEmail:
+			if strings.EqualFold(candidate.Email, to) {
+				return candidate, true
+			}
+		case ChannelSMS:
+			if candidate.Phone == to {
+				return candidate, true
+			}
+		case ChannelPush:
+			if candidate.DeviceToken == to {
+				return candidate, true
+			}
+		}
+	}
+
+	return datasets.Recipient{}, false
+}
@@ -0,0 +1,59 @@
+package delivery
+
+import (
+	"errors"
+	"hermes/pkg/common/redact"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+)
+
+// SESSender sends emails through SES from a verified address. Every email
+// carries a test tag, which SES event publishing passes on.
+type SESSender struct {
+	client sesiface.SESAPI
+	from   string
+}
+
+func NewSESSender(client sesiface.SESAPI, from string) *SESSender {
+	return &SESSender{client: client, from: from}
+}
+
+func (s *SESSender) Send(m Message) (string, error) {
+	if m.Rendered == nil || m.Rendered.Email == nil {
+		return "", errors.New(ErrorMissingContent)
+	}
+	if len(s.from) == 0 {
+		return "", errors.New(ErrorChannelUnavailable)
+	}
+
+	email := m.Rendered.Email
+	body := &ses.Body{}
+	if len(email.HTML) > 0 {
+		body.Html = &ses.Content{Charset: aws.String("UTF-8"), Data: aws.String(email.HTML)}
+	}
+	if len(email.Text) > 0 {
+		body.Text = &ses.Content{Charset: aws.String("UTF-8"), Data: aws.String(email.Text)}
+	}
+
+	out, err := s.client.SendEmail(&ses.SendEmailInput{
+		Source:      aws.String(s.from),
+		Destination: &ses.Destination{ToAddresses: []*string{aws.String(m.To)}},
+		Message: &ses.Message{
+			Subject: &ses.Content{Charset: aws.String("UTF-8"), Data: aws.String(email.Subject)},
+			Body:    body,
+		},
+		Tags: []*ses.MessageTag{
+			{Name: aws.String("hermes-message"), Value: aws.String(m.Id)},
+			{Name: aws.String("hermes-test"), Value: aws.String(strconv.FormatBool(m.Test))},
+		},
+	})
+	if err != nil {
+		redact.Println(err)
+		return "", errors.New(ErrorCouldNotDeliver)
+	}
+
+	return aws.StringValue(out.MessageId), nil
+}
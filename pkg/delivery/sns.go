@@ -0,0 +1,131 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"hermes/pkg/common/redact"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// SMSSender sends text messages through SNS as transactional messages.
+type SMSSender struct {
+	client   snsiface.SNSAPI
+	senderId string
+}
+
+func NewSMSSender(client snsiface.SNSAPI, senderId string) *SMSSender {
+	return &SMSSender{client: client, senderId: senderId}
+}
+
+func (s *SMSSender) Send(m Message) (string, error) {
+	if m.Rendered == nil || m.Rendered.SMS == nil {
+		return "", errors.New(ErrorMissingContent)
+	}
+
+	attributes := map[string]*sns.MessageAttributeValue{
+		"AWS.SNS.SMS.SMSType": {DataType: aws.String("String"), StringValue: aws.String("Transactional")},
+	}
+	if len(s.senderId) > 0 {
+		attributes["AWS.SNS.SMS.SenderID"] = &sns.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(s.senderId)}
+	}
+
+	out, err := s.client.Publish(&sns.PublishInput{
+		PhoneNumber:       aws.String(m.To),
+		Message:           aws.String(m.Rendered.SMS.Body),
+		MessageAttributes: attributes,
+	})
+	if err != nil {
+		redact.Println(err)
+		return "", errors.New(ErrorCouldNotDeliver)
+	}
+
+	return aws.StringValue(out.MessageId), nil
+}
+
+// PushSender sends push notifications to device tokens through an SNS
+// platform application. Test pushes carry hermesTest in their data.
+type PushSender struct {
+	client                 snsiface.SNSAPI
+	platformApplicationArn string
+}
+
+func NewPushSender(client snsiface.SNSAPI, platformApplicationArn string) *PushSender {
+	return &PushSender{client: client, platformApplicationArn: platformApplicationArn}
+}
+
+func (s *PushSender) Send(m Message) (string, error) {
+	if m.Rendered == nil || m.Rendered.Push == nil {
+		return "", errors.New(ErrorMissingContent)
+	}
+	if len(s.platformApplicationArn) == 0 {
+		return "", errors.New(ErrorChannelUnavailable)
+	}
+
+	endpoint, err := s.client.CreatePlatformEndpoint(&sns.CreatePlatformEndpointInput{
+		PlatformApplicationArn: aws.String(s.platformApplicationArn),
+		Token:                  aws.String(m.To),
+	})
+	if err != nil {
+		redact.Println(err)
+		return "", errors.New(ErrorCouldNotDeliver)
+	}
+
+	payload, err := pushPayload(m)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := s.client.Publish(&sns.PublishInput{
+		TargetArn:        endpoint.EndpointArn,
+		MessageStructure: aws.String("json"),
+		Message:          aws.String(payload),
+	})
+	if err != nil {
+		redact.Println(err)
+		return "", errors.New(ErrorCouldNotDeliver)
+	}
+
+	return aws.StringValue(out.MessageId), nil
+}
+
+// pushPayload builds the message for APNs and FCM, which SNS expects as
+// strings keyed by platform.
+func pushPayload(m Message) (string, error) {
+	push := m.Rendered.Push
+	data := map[string]string{"hermesMessageId": m.Id}
+	for key, value := range push.Data {
+		data[key] = value
+	}
+	if m.Test {
+		data["hermesTest"] = "true"
+	}
+
+	apns := map[string]interface{}{
+		"aps": map[string]interface{}{"alert": map[string]string{"title": push.Title, "body": push.Body}},
+	}
+	for key, value := range data {
+		apns[key] = value
+	}
+
+	gcm := map[string]interface{}{
+		"notification": map[string]string{"title": push.Title, "body": push.Body},
+		"data":         data,
+	}
+
+	apnsJSON, _ := json.Marshal(apns)
+	gcmJSON, _ := json.Marshal(gcm)
+	payload, err := json.Marshal(map[string]string{
+		"default":      push.Body,
+		"APNS":         string(apnsJSON),
+		"APNS_SANDBOX": string(apnsJSON),
+		"GCM":          string(gcmJSON),
+	})
+	if err != nil {
+		return "", errors.New(ErrorCouldNotDeliver)
+	}
+
+	return string(payload), nil
+}
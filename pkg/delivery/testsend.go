@@ -0,0 +1,123 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/layouts"
+	"hermes/pkg/links"
+	"hermes/pkg/notifications"
+	"hermes/pkg/rendering"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var (
+	// TestSMSPrefix starts the body of test text messages, which have no
+	// other way to be told apart, unlike emails and pushes.
+	TestSMSPrefix            = "[TEST] "
+	ErrorInvalidTestSendData = "invalid test send request"
+	ErrorTestSendMissingRow  = "a row is required to test a notification with a dataset query"
+)
+
+// TestSendRequest addresses a test message. Row and Inputs are the sample
+// data it is rendered with; Row is required when the notification has a
+// dataset query, as its templates and rules read the row. Template forces
+// a template instead of the one the rules select for the row.
+type TestSendRequest struct {
+	Channel  string                 `json:"channel"`
+	To       string                 `json:"to"`
+	Row      map[string]interface{} `json:"row"`
+	Inputs   map[string]interface{} `json:"inputs"`
+	Locale   string                 `json:"locale"`
+	Template *int                   `json:"template"`
+}
+
+type TestSendResult struct {
+	Message  MessageLog          `json:"message"`
+	Template int                 `json:"template"`
+	Rendered *rendering.Rendered `json:"rendered"`
+}
+
+// SendTest renders a notification for sample data the way it is rendered
+// for a send, tracked links included, and delivers it to a single address.
+func SendTest(req events.APIGatewayProxyRequest, repo crud.CrudRepository, layoutRepo crud.CrudRepository, linkRepo crud.CrudRepository, messages *MessageRepository, id string) (
+	*TestSendResult,
+	error,
+) {
+	var r TestSendRequest
+	if err := json.Unmarshal([]byte(req.Body), &r); err != nil {
+		return nil, errors.New(ErrorInvalidTestSendData)
+	}
+
+	if err := ValidateAddress(r.Channel, r.To); err != nil {
+		return nil, err
+	}
+
+	n, _ := notifications.FetchNotification(id, repo)
+	if n != nil && len(n.Name) == 0 {
+		return nil, errors.New(notifications.ErrorNotificationDoesNotExists)
+	}
+
+	if r.Row == nil && len(n.Query.DataSetId) > 0 && len(n.Query.Query) > 0 {
+		return nil, errors.New(ErrorTestSendMissingRow)
+	}
+
+	data := rendering.Data{Row: r.Row, Locale: r.Locale}
+	if data.Row == nil {
+		data.Row = map[string]interface{}{}
+	}
+	inputs, err := n.Inputs.ResolveSupplied(r.Inputs)
+	if err != nil {
+		return nil, err
+	}
+	data.Inputs = inputs
+
+	message := Message{
+		Id:             NewMessageId(time.Now()),
+		NotificationId: n.Id,
+		Channel:        r.Channel,
+		To:             r.To,
+		Test:           true,
+	}
+
+	opts := rendering.Options{
+		Layouts: layouts.NewLibrary(layoutRepo),
+		Links:   links.NewTrackerFromEnv(linkRepo),
+		Context: links.Context{NotificationId: n.Id, Recipient: r.To, Test: true},
+	}
+
+	index, ok := n.SelectTemplate(data.Row, data.Inputs)
+	if r.Template != nil {
+		if *r.Template < 0 || *r.Template >= len(n.Templates) {
+			return nil, errors.New(rendering.ErrorTemplateDoesNotExist)
+		}
+		index, ok = *r.Template, true
+	}
+	if !ok {
+		return nil, errors.New(rendering.ErrorNoTemplateMatches)
+	}
+
+	rendered, err := rendering.RenderContent(n.Templates[index], r.Channel, data, opts)
+	if err != nil {
+		return nil, fmt.Errorf("template %d: %v", index, err)
+	}
+	if rendered.SMS != nil {
+		body := TestSMSPrefix + rendered.SMS.Body
+		if err := (notifications.SMSContent{Body: body}).Validate(); err != nil {
+			return nil, fmt.Errorf("template %d: sms: %v", index, err)
+		}
+		rendered.SMS.Body = body
+		rendered.SMS.Segments, rendered.SMS.Encoding = notifications.SMSSegments(body)
+	}
+	message.Rendered = rendered
+
+	entry, err := Deliver(message, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TestSendResult{Message: *entry, Template: index, Rendered: rendered}, nil
+}
@@ -18,6 +18,15 @@ func ApiResponse(status int, body interface{}) (*events.APIGatewayProxyResponse,
 	return &resp, nil
 }
 
+// SensitiveApiResponse serialises a body that carries secrets, which must
+// not be cached along the way. Only endpoints that are authorised to
+// return secrets may use it.
+func SensitiveApiResponse(status int, body interface{}) (*events.APIGatewayProxyResponse, error) {
+	resp, err := ApiResponse(status, body)
+	resp.Headers["Cache-Control"] = "no-store"
+	return resp, err
+}
+
 func UnhandledMethod() (*events.APIGatewayProxyResponse, error) {
 	return ApiResponse(http.StatusMethodNotAllowed, ErrorMethodNotAllowed)
 }
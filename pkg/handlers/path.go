@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// PathAction splits the greedy {id+} path parameter into the resource id and
+// the action requested on it, e.g. "abc/upload" becomes ("abc", "upload").
+func PathAction(req events.APIGatewayProxyRequest) (string, string) {
+	parts := strings.SplitN(strings.Trim(req.PathParameters["id"], "/"), "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
@@ -0,0 +1,100 @@
+package layouts
+
+import (
+	"hermes/pkg/common/crud"
+	BaseErrors "hermes/pkg/common/errors"
+	"hermes/pkg/handlers"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type ErrorBody struct {
+	ErrorMsg *string `json:"error,omitempty"`
+}
+
+func GetLayout(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	id := req.QueryStringParameters["id"]
+	if len(id) > 0 {
+		result, err := FetchLayout(id, repo)
+		if err != nil {
+			return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{aws.String(err.Error())})
+		}
+
+		return handlers.ApiResponse(http.StatusOK, result)
+	}
+
+	result, err := FetchLayouts(repo)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, result)
+}
+
+func NewLayout(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := CreateLayout(req, repo)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusCreated, result)
+}
+
+func SaveLayout(req events.APIGatewayProxyRequest, repo crud.CrudRepository, notificationRepo crud.CrudRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := UpdateLayout(req, repo, notificationRepo)
+	if err != nil {
+		if err.Error() == BaseErrors.ErrorItemVersionChanged {
+			return handlers.ApiResponse(http.StatusConflict, ErrorBody{
+				aws.String(err.Error()),
+			})
+		}
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, result)
+}
+
+func RemoveLayout(req events.APIGatewayProxyRequest, repo crud.CrudRepository, notificationRepo crud.CrudRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	err := DeleteLayout(req, repo, notificationRepo)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, nil)
+}
+
+func GetLayoutUsage(req events.APIGatewayProxyRequest, repo crud.CrudRepository, notificationRepo crud.CrudRepository, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	current, _ := FetchLayout(id, repo)
+	if current != nil && len(current.Name) == 0 {
+		return handlers.ApiResponse(http.StatusNotFound, ErrorBody{aws.String(ErrorLayoutDoesNotExists)})
+	}
+
+	result, err := LayoutUsage(id, repo, notificationRepo)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, result)
+}
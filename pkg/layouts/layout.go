@@ -0,0 +1,299 @@
+package layouts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hermes/pkg/common/crud"
+	BaseErrors "hermes/pkg/common/errors"
+	"hermes/pkg/common/redact"
+	"hermes/pkg/rendering/funcs"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	KindLayout  = "layout"
+	KindPartial = "partial"
+	// ContentBlock is the block of a layout the body extending it fills.
+	ContentBlock = "content"
+)
+
+var (
+	ErrorInvalidLayoutData   = "invalid layout data"
+	ErrorInvalidLayoutKind   = "invalid kind. Only layout and partial are supported"
+	ErrorLayoutMissingId     = "layout is missing its id"
+	ErrorLayoutAlreadyExists = "Layout already exists"
+	ErrorLayoutDoesNotExists = "Layout does not exist"
+	ErrorLayoutNoContent     = "a layout must render the content block, e.g. {{block \"content\" .}}{{end}}"
+	ErrorInvalidLayout       = "invalid layout"
+	ErrorManyExtends         = "a template can only extend one layout"
+	ErrorNestedExtends       = "layouts and partials can't extend other layouts"
+	ErrorLayoutInUse         = "layout is in use by"
+	ErrorLayoutKindInUse     = "the kind of a layout in use can't change. It is used by"
+)
+
+// Layout is shared template content. A layout wraps the body of templates
+// that start with {{extends "id"}}, which fills its content block; a
+// partial is included by id anywhere with {{template "id" .}}. Templates
+// pick up the current version of both whenever they are rendered.
+type Layout struct {
+	Id        string   `json:"id"`
+	Name      string   `json:"name"`
+	Kind      string   `json:"kind"`
+	Content   string   `json:"content"`
+	Version   int      `json:"version"`
+	UpdatedAt string   `json:"updatedAt"`
+	Tags      []string `json:"tags"`
+}
+
+func FetchLayout(id string, repo crud.CrudRepository) (*Layout, error) {
+	item := new(Layout)
+
+	_, err := repo.Get(id, item)
+
+	return item, err
+}
+
+func FetchLayouts(repo crud.CrudRepository) (*[]Layout, error) {
+	item := new([]Layout)
+
+	_, err := repo.List(item)
+
+	return item, err
+}
+
+func CreateLayout(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (*Layout, error) {
+	var l Layout
+	if err := json.Unmarshal([]byte(req.Body), &l); err != nil {
+		return nil, errors.New(ErrorInvalidLayoutData)
+	}
+
+	currentLayout, _ := FetchLayout(l.Id, repo)
+	if currentLayout != nil && len(currentLayout.Name) > 0 {
+		return nil, errors.New(ErrorLayoutAlreadyExists)
+	}
+
+	if err := ValidateLayout(&l); err != nil {
+		return nil, err
+	}
+
+	l.Version = 1
+	l.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	_, err := repo.Create(l)
+
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// UpdateLayout replaces a layout. Its kind only changes while nothing uses
+// it, and the update fails if the layout was updated since it was read.
+func UpdateLayout(req events.APIGatewayProxyRequest, repo crud.CrudRepository, notificationRepo crud.CrudRepository) (*Layout, error) {
+	var l Layout
+	if err := json.Unmarshal([]byte(req.Body), &l); err != nil {
+		return nil, errors.New(ErrorInvalidLayoutData)
+	}
+
+	currentLayout, _ := FetchLayout(l.Id, repo)
+	if currentLayout != nil && len(currentLayout.Name) == 0 {
+		return nil, errors.New(ErrorLayoutDoesNotExists)
+	}
+
+	if err := ValidateLayout(&l); err != nil {
+		return nil, err
+	}
+
+	if l.Kind != currentLayout.Kind {
+		usage, err := LayoutUsage(l.Id, repo, notificationRepo)
+		if err != nil {
+			return nil, err
+		}
+		if len(usage.Notifications) > 0 || len(usage.Layouts) > 0 {
+			return nil, fmt.Errorf("%s: %s", ErrorLayoutKindInUse, strings.Join(usage.ids(), ", "))
+		}
+	}
+
+	l.Version = currentLayout.Version + 1
+	l.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	if err := repo.UpdateVersion(l.Id, l, currentLayout.Version); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// DeleteLayout deletes a layout nothing uses.
+func DeleteLayout(req events.APIGatewayProxyRequest, repo crud.CrudRepository, notificationRepo crud.CrudRepository) error {
+	id := req.PathParameters["id"]
+
+	currentLayout, _ := FetchLayout(id, repo)
+	if currentLayout != nil && len(currentLayout.Name) == 0 {
+		return nil
+	}
+
+	usage, err := LayoutUsage(id, repo, notificationRepo)
+	if err != nil {
+		return err
+	}
+	if len(usage.Notifications) > 0 || len(usage.Layouts) > 0 {
+		return fmt.Errorf("%s: %s", ErrorLayoutInUse, strings.Join(usage.ids(), ", "))
+	}
+
+	err = repo.Delete(id)
+	if err != nil {
+		redact.Println(err)
+		return errors.New(BaseErrors.ErrorCouldNotDeleteItem)
+	}
+
+	return nil
+}
+
+// ValidateLayout checks the kind of a layout and parses its content.
+func ValidateLayout(l *Layout) error {
+	if len(strings.TrimSpace(l.Id)) == 0 {
+		return errors.New(ErrorLayoutMissingId)
+	}
+
+	if l.Kind != KindLayout && l.Kind != KindPartial {
+		return errors.New(ErrorInvalidLayoutKind)
+	}
+
+	refs, err := References(l.Content)
+	if err != nil {
+		return fmt.Errorf("%s: %v", ErrorInvalidLayout, err)
+	}
+
+	if len(refs.Extends) > 0 {
+		return errors.New(ErrorNestedExtends)
+	}
+
+	if l.Kind == KindLayout && !refs.hasContent {
+		return errors.New(ErrorLayoutNoContent)
+	}
+
+	return nil
+}
+
+// Refs are the layout a template source extends and the partials it
+// includes.
+type Refs struct {
+	Extends  string
+	Includes []string
+	// Defines are the templates the source defines, which are not
+	// partials when another source of the same template includes them.
+	Defines []string
+	// hasContent tells whether the source renders the content block.
+	hasContent bool
+}
+
+// References parses a template source and returns the layout and partials
+// it refers to. Templates the source defines itself are not partials.
+func References(source string) (*Refs, error) {
+	tmpl, err := template.New("").Funcs(funcs.Map()).Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := &Refs{}
+	defined := map[string]bool{}
+	included := map[string]bool{}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if len(t.Name()) > 0 {
+			defined[t.Name()] = true
+			refs.Defines = append(refs.Defines, t.Name())
+		}
+		walk(t.Tree.Root, func(node parse.Node) {
+			switch n := node.(type) {
+			case *parse.TemplateNode:
+				if n.Name == ContentBlock {
+					refs.hasContent = true
+				}
+				included[n.Name] = true
+			case *parse.ActionNode:
+				if name, ok := extendsName(n); ok && t.Name() == "" {
+					if len(refs.Extends) > 0 && refs.Extends != name {
+						err = errors.New(ErrorManyExtends)
+					}
+					refs.Extends = name
+				}
+			}
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range included {
+		if !defined[name] && name != ContentBlock {
+			refs.Includes = append(refs.Includes, name)
+		}
+	}
+	sort.Strings(refs.Includes)
+	sort.Strings(refs.Defines)
+
+	return refs, nil
+}
+
+// extendsName reads {{extends "id"}}.
+func extendsName(n *parse.ActionNode) (string, bool) {
+	if n.Pipe == nil || len(n.Pipe.Cmds) != 1 || len(n.Pipe.Cmds[0].Args) != 2 {
+		return "", false
+	}
+
+	args := n.Pipe.Cmds[0].Args
+	fn, ok := args[0].(*parse.IdentifierNode)
+	if !ok || fn.Ident != "extends" {
+		return "", false
+	}
+
+	name, ok := args[1].(*parse.StringNode)
+	if !ok {
+		return "", false
+	}
+
+	return name.Text, true
+}
+
+func walk(node parse.Node, fn func(parse.Node)) {
+	if node == nil {
+		return
+	}
+	fn(node)
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walk(child, fn)
+		}
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, fn)
+	}
+}
+
+func walkBranch(n *parse.BranchNode, fn func(parse.Node)) {
+	if n.List != nil {
+		walk(n.List, fn)
+	}
+	if n.ElseList != nil {
+		walk(n.ElseList, fn)
+	}
+}
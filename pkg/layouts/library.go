@@ -0,0 +1,113 @@
+package layouts
+
+import (
+	"errors"
+	"fmt"
+	"hermes/pkg/common/crud"
+)
+
+var (
+	ErrorLayoutsUnavailable = "layouts are not available"
+	ErrorNotALayout         = "only layouts can be extended"
+)
+
+// Library loads the layouts and partials templates refer to. It keeps
+// those it loaded, so a render pass reads each once, but it is meant to
+// live for a single request: a new one sees the layouts as they are now.
+type Library struct {
+	repo    crud.CrudRepository
+	layouts map[string]*Layout
+}
+
+func NewLibrary(repo crud.CrudRepository) *Library {
+	return &Library{repo: repo, layouts: map[string]*Layout{}}
+}
+
+// Get returns the layout or partial with the given id.
+func (lib *Library) Get(id string) (*Layout, error) {
+	if lib == nil || lib.repo == nil {
+		return nil, errors.New(ErrorLayoutsUnavailable)
+	}
+
+	if l, ok := lib.layouts[id]; ok {
+		return l, nil
+	}
+
+	l, _ := FetchLayout(id, lib.repo)
+	if l == nil || len(l.Name) == 0 {
+		return nil, fmt.Errorf("%s: %s", ErrorLayoutDoesNotExists, id)
+	}
+
+	lib.layouts[id] = l
+	return l, nil
+}
+
+// Source is a named template source of a composed template.
+type Source struct {
+	Name   string
+	Source string
+}
+
+// Compose returns the sources a template is made of. The first is the one
+// to execute: the source itself or, when it extends a layout, the layout,
+// followed by the source as its content block. The partials any of them
+// include come after, along with the partials those include.
+func (lib *Library) Compose(name string, source string) ([]Source, error) {
+	refs, err := References(source)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []Source{{name, source}}
+	defined := map[string]bool{}
+	for _, d := range refs.Defines {
+		defined[d] = true
+	}
+	pending := refs.Includes
+
+	if len(refs.Extends) > 0 {
+		layout, err := lib.Get(refs.Extends)
+		if err != nil {
+			return nil, err
+		}
+		if layout.Kind != KindLayout {
+			return nil, fmt.Errorf("%s: %s", ErrorNotALayout, refs.Extends)
+		}
+
+		layoutRefs, err := References(layout.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", ErrorInvalidLayout, layout.Id, err)
+		}
+		for _, d := range layoutRefs.Defines {
+			defined[d] = true
+		}
+
+		sources = []Source{{name, layout.Content}, {ContentBlock, source}}
+		pending = append(pending, layoutRefs.Includes...)
+	}
+
+	loaded := map[string]bool{}
+	for len(pending) > 0 {
+		id := pending[0]
+		pending = pending[1:]
+		if loaded[id] || defined[id] {
+			continue
+		}
+		loaded[id] = true
+
+		partial, err := lib.Get(id)
+		if err != nil {
+			return nil, err
+		}
+
+		partialRefs, err := References(partial.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", ErrorInvalidLayout, partial.Id, err)
+		}
+
+		sources = append(sources, Source{id, partial.Content})
+		pending = append(pending, partialRefs.Includes...)
+	}
+
+	return sources, nil
+}
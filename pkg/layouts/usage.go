@@ -0,0 +1,109 @@
+package layouts
+
+import (
+	"hermes/pkg/common/crud"
+	"hermes/pkg/notifications"
+	"sort"
+)
+
+// NotificationUsage is a notification using a layout, either directly or
+// through the layouts and partials it uses, and the templates that do.
+type NotificationUsage struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Templates []int  `json:"templates"`
+}
+
+// Usage lists what uses a layout or partial. Layouts lists the layouts
+// and partials that include it.
+type Usage struct {
+	Layout        string              `json:"layout"`
+	Version       int                 `json:"version"`
+	Layouts       []string            `json:"layouts"`
+	Notifications []NotificationUsage `json:"notifications"`
+}
+
+func (u Usage) ids() []string {
+	ids := append([]string{}, u.Layouts...)
+	for _, n := range u.Notifications {
+		ids = append(ids, n.Id)
+	}
+
+	return ids
+}
+
+// LayoutUsage finds the notifications whose templates render with a
+// layout or partial, following the partials layouts include.
+func LayoutUsage(id string, repo crud.CrudRepository, notificationRepo crud.CrudRepository) (*Usage, error) {
+	all, err := FetchLayouts(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	includes := make(map[string][]string, len(*all))
+	usage := &Usage{Layout: id, Layouts: []string{}, Notifications: []NotificationUsage{}}
+	for _, l := range *all {
+		if l.Id == id {
+			usage.Version = l.Version
+		}
+		refs, err := References(l.Content)
+		if err != nil {
+			continue
+		}
+		includes[l.Id] = refs.Includes
+	}
+
+	uses := func(refs []string) bool {
+		seen := map[string]bool{}
+		for len(refs) > 0 {
+			ref := refs[0]
+			refs = refs[1:]
+			if ref == id {
+				return true
+			}
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			refs = append(refs, includes[ref]...)
+		}
+		return false
+	}
+
+	for layoutId, refs := range includes {
+		if layoutId != id && uses(refs) {
+			usage.Layouts = append(usage.Layouts, layoutId)
+		}
+	}
+	sort.Strings(usage.Layouts)
+
+	list, err := notifications.FetchNotifications(notificationRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range *list {
+		templates := []int{}
+		for i, t := range n.Templates {
+			refs := []string{}
+			for _, source := range t.Sources() {
+				r, err := References(source)
+				if err != nil {
+					continue
+				}
+				if len(r.Extends) > 0 {
+					refs = append(refs, r.Extends)
+				}
+				refs = append(refs, r.Includes...)
+			}
+			if uses(refs) {
+				templates = append(templates, i)
+			}
+		}
+		if len(templates) > 0 {
+			usage.Notifications = append(usage.Notifications, NotificationUsage{Id: n.Id, Name: n.Name, Templates: templates})
+		}
+	}
+
+	return usage, nil
+}
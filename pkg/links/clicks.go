@@ -0,0 +1,55 @@
+package links
+
+import (
+	"errors"
+	BaseErrors "hermes/pkg/common/errors"
+	"hermes/pkg/common/redact"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Click is one visit of a tracked link, with the context of the link.
+type Click struct {
+	LinkId    string `json:"linkId"`
+	ClickedAt string `json:"clickedAt"`
+	Url       string `json:"url"`
+	UserAgent string `json:"userAgent,omitempty"`
+	SourceIP  string `json:"sourceIp,omitempty"`
+	ExpiresAt int64  `json:"expiresAt,omitempty"`
+	Context
+}
+
+// ClickRepository stores clicks in a table keyed by linkId and clickedAt.
+// Clicks expire along with their link through the table TTL on expiresAt.
+type ClickRepository struct {
+	dynaClient dynamodbiface.DynamoDBAPI
+	tableName  string
+}
+
+func InitClickRepo(t string, d dynamodbiface.DynamoDBAPI) *ClickRepository {
+	return &ClickRepository{
+		dynaClient: d,
+		tableName:  t,
+	}
+}
+
+func (c *ClickRepository) Record(click Click) error {
+	av, err := dynamodbattribute.MarshalMap(click)
+	if err != nil {
+		return errors.New(BaseErrors.ErrorCouldNotMarshalItem)
+	}
+
+	_, err = c.dynaClient.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(c.tableName),
+	})
+	if err != nil {
+		redact.Println(err)
+		return errors.New(BaseErrors.ErrorCouldNotDynamoPutItem)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package links
+
+import (
+	"hermes/pkg/common/redact"
+	"hermes/pkg/handlers"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type ErrorBody struct {
+	ErrorMsg *string `json:"error,omitempty"`
+}
+
+// Redirect forwards a short link to its target once the click is logged.
+// A click that can't be logged is still forwarded.
+func Redirect(req events.APIGatewayProxyRequest, tracker *Tracker, clicks *ClickRepository) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	if tracker == nil {
+		return handlers.ApiResponse(http.StatusNotFound, ErrorBody{aws.String(ErrorLinkDoesNotExists)})
+	}
+
+	code := strings.Trim(req.PathParameters["code"], "/")
+	l, err := tracker.Resolve(code)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusNotFound, ErrorBody{aws.String(err.Error())})
+	}
+
+	click := Click{
+		LinkId:    l.Id,
+		ClickedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		Url:       l.Url,
+		UserAgent: req.RequestContext.Identity.UserAgent,
+		SourceIP:  req.RequestContext.Identity.SourceIP,
+		ExpiresAt: l.ExpiresAt,
+		Context:   l.Context,
+	}
+	if err := clicks.Record(click); err != nil {
+		redact.Println("could not log click on link", l.Id, err)
+	}
+
+	return &events.APIGatewayProxyResponse{
+		StatusCode: http.StatusFound,
+		Headers:    map[string]string{"Location": l.Url, "Cache-Control": "no-store"},
+	}, nil
+}
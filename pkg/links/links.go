@@ -0,0 +1,145 @@
+package links
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/common/redact"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	DefaultLinkTTL         = 180 * 24 * time.Hour
+	ErrorLinkDoesNotExists = "link does not exist"
+	ErrorLinkExpired       = "link has expired"
+	ErrorLinkNotSigned     = "link signature does not match"
+	ErrorCouldNotStoreLink = "could not store tracked link"
+	ErrorMissingSigningKey = "LINK_BASE_URL is set without a LINK_SIGNING_KEY to sign links with"
+)
+
+// Context is what a tracked link tells about the message it was sent in.
+// Recipient is the address the message was sent to; Test marks links of
+// test messages, so their clicks are told apart.
+type Context struct {
+	CampaignId     string `json:"campaignId,omitempty"`
+	NotificationId string `json:"notificationId,omitempty"`
+	Recipient      string `json:"recipient,omitempty"`
+	Test           bool   `json:"test,omitempty"`
+}
+
+// Link is a tracked link. Its id is the short code of its URL, which signs
+// the target and the context, so the redirect only forwards links Hermes
+// issued as they were issued.
+type Link struct {
+	Id        string `json:"id"`
+	Url       string `json:"url"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt int64  `json:"expiresAt"`
+	Context
+}
+
+// Tracker turns URLs into tracked short links, stored in the links table
+// and served under BaseURL. It is meant to live for a send, so it stores
+// each link it issues once.
+type Tracker struct {
+	repo    crud.CrudRepository
+	baseURL string
+	key     []byte
+	issued  map[string]bool
+}
+
+// NewTracker returns nil, which leaves links as they are written, when
+// there is no base URL or signing key to issue them with.
+func NewTracker(repo crud.CrudRepository, baseURL string, key string) *Tracker {
+	if len(baseURL) == 0 || len(key) == 0 {
+		return nil
+	}
+
+	return &Tracker{repo: repo, baseURL: strings.TrimRight(baseURL, "/"), key: []byte(key), issued: map[string]bool{}}
+}
+
+// CheckEnv fails when link tracking is configured without a signing key,
+// which would otherwise leave every link untracked without a word. It is
+// meant to run when a lambda starts.
+func CheckEnv() error {
+	if len(os.Getenv("LINK_BASE_URL")) > 0 && len(os.Getenv("LINK_SIGNING_KEY")) == 0 {
+		return errors.New(ErrorMissingSigningKey)
+	}
+
+	return nil
+}
+
+// NewTrackerFromEnv reads LINK_BASE_URL and LINK_SIGNING_KEY.
+func NewTrackerFromEnv(repo crud.CrudRepository) *Tracker {
+	return NewTracker(repo, os.Getenv("LINK_BASE_URL"), os.Getenv("LINK_SIGNING_KEY"))
+}
+
+// Shorten stores a tracked link to target and returns its short URL. The
+// same target and context always get the same link.
+func (t *Tracker) Shorten(target string, ctx Context) (string, error) {
+	code := t.code(target, ctx)
+	short := t.baseURL + "/" + code
+	if t.issued[code] {
+		return short, nil
+	}
+
+	now := time.Now()
+	l := Link{
+		Id:        code,
+		Url:       target,
+		CreatedAt: now.UTC().Format(time.RFC3339),
+		ExpiresAt: now.Add(linkTTL()).Unix(),
+		Context:   ctx,
+	}
+	if _, err := t.repo.Create(l); err != nil {
+		redact.Println(err)
+		return "", errors.New(ErrorCouldNotStoreLink)
+	}
+
+	t.issued[code] = true
+	return short, nil
+}
+
+// Resolve returns the link a short code stands for, once its signature
+// and expiry are checked.
+func (t *Tracker) Resolve(code string) (*Link, error) {
+	l := new(Link)
+	if _, err := t.repo.Get(code, l); err != nil {
+		return nil, err
+	}
+	if len(l.Id) == 0 {
+		return nil, errors.New(ErrorLinkDoesNotExists)
+	}
+
+	if !hmac.Equal([]byte(t.code(l.Url, l.Context)), []byte(code)) {
+		return nil, errors.New(ErrorLinkNotSigned)
+	}
+
+	if l.ExpiresAt > 0 && time.Now().Unix() > l.ExpiresAt {
+		return nil, errors.New(ErrorLinkExpired)
+	}
+
+	return l, nil
+}
+
+// code signs the target and the context with the signing key and keeps
+// the first 72 bits, 12 characters once encoded.
+func (t *Tracker) code(target string, ctx Context) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(strings.Join([]string{target, ctx.CampaignId, ctx.NotificationId, ctx.Recipient, strconv.FormatBool(ctx.Test)}, "\x00")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:9])
+}
+
+// linkTTL reads LINK_TTL, in Go duration syntax.
+func linkTTL() time.Duration {
+	if v, err := time.ParseDuration(strings.TrimSpace(os.Getenv("LINK_TTL"))); err == nil && v > 0 {
+		return v
+	}
+
+	return DefaultLinkTTL
+}
@@ -0,0 +1,109 @@
+package links
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// NoTrack ends a URL that is left as written, e.g.
+	// https://example.com/terms#notrack, and is removed from the output
+	// whether links are tracked or not. In html, a data-notrack attribute
+	// on the anchor does the same.
+	NoTrack = "#notrack"
+
+	textURL     = regexp.MustCompile(`https?://[^\s<>"']+`)
+	anchorTag   = regexp.MustCompile(`(?i)<a\s[^>]*>`)
+	hrefAttr    = regexp.MustCompile(`(?i)(\shref\s*=\s*)("[^"]*"|'[^']*')`)
+	noTrackAttr = regexp.MustCompile(`(?i)\sdata-notrack(\s*=\s*("[^"]*"|'[^']*'|[^\s>]+))?`)
+)
+
+// RewriteText replaces every http and https URL in text with a tracked
+// link. A nil tracker only removes the NoTrack markers.
+func (t *Tracker) RewriteText(text string, ctx Context) (string, error) {
+	var err error
+	result := textURL.ReplaceAllStringFunc(text, func(match string) string {
+		target, trailing := trimURL(match)
+		link, e := t.link(target, ctx, false)
+		if e != nil {
+			err = e
+			return match
+		}
+		return link + trailing
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return result, nil
+}
+
+// RewriteHTML replaces the href of every anchor in source with a tracked
+// link, but for anchors with a data-notrack attribute, which is removed.
+// A nil tracker only removes the opt outs.
+func (t *Tracker) RewriteHTML(source string, ctx Context) (string, error) {
+	var err error
+	result := anchorTag.ReplaceAllStringFunc(source, func(tag string) string {
+		optOut := noTrackAttr.MatchString(tag)
+		tag = noTrackAttr.ReplaceAllString(tag, "")
+
+		return hrefAttr.ReplaceAllStringFunc(tag, func(attr string) string {
+			m := hrefAttr.FindStringSubmatch(attr)
+			quote := m[2][:1]
+			target := html.UnescapeString(m[2][1 : len(m[2])-1])
+
+			link, e := t.link(target, ctx, optOut)
+			if e != nil {
+				err = e
+				return attr
+			}
+			if link == target {
+				return attr
+			}
+			return m[1] + quote + html.EscapeString(link) + quote
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// URLs written as text are not rewritten, but may carry the marker
+	return strings.ReplaceAll(result, NoTrack, ""), nil
+}
+
+func (t *Tracker) link(target string, ctx Context, optOut bool) (string, error) {
+	if strings.HasSuffix(target, NoTrack) {
+		return strings.TrimSuffix(target, NoTrack), nil
+	}
+
+	if optOut || t == nil || !isWebURL(target) {
+		return target, nil
+	}
+
+	return t.Shorten(target, ctx)
+}
+
+func isWebURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || len(u.Host) == 0 {
+		return false
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	return scheme == "http" || scheme == "https"
+}
+
+// trimURL splits the punctuation that ends the sentence a URL is written
+// in from the URL.
+func trimURL(match string) (string, string) {
+	target := strings.TrimRight(match, ".,;:!?)]}")
+	return target, match[len(target):]
+}
+
+// StripNoTrack removes the NoTrack markers of text that is never tracked,
+// such as subject lines.
+func StripNoTrack(text string) string {
+	return strings.ReplaceAll(text, NoTrack, "")
+}
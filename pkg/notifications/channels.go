@@ -0,0 +1,250 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+var (
+	MaxEmailSubjectLength = 255
+	MaxSMSSegments        = 4
+	MaxPushPayloadBytes   = 4096
+	ErrorEmailSubjectLong = "email subject is too long"
+	ErrorEmailEmpty       = "email content needs an html or a text part"
+	ErrorSMSEmpty         = "sms content needs a body"
+	ErrorSMSTooLong       = "sms body is too long"
+	ErrorPushEmpty        = "push content needs a title or a body"
+	ErrorPushTooLarge     = "push payload is too large"
+)
+
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelPush  = "push"
+
+	SMSEncodingGSM7 = "gsm7"
+	SMSEncodingUCS2 = "ucs2"
+)
+
+// Channels holds the content of a template for each channel. Blocks that
+// are left out are built from Title and Body.
+type Channels struct {
+	Email *EmailContent `json:"email,omitempty"`
+	SMS   *SMSContent   `json:"sms,omitempty"`
+	Push  *PushContent  `json:"push,omitempty"`
+}
+
+// EmailContent is an email. Subject defaults to the template title.
+type EmailContent struct {
+	Subject string `json:"subject,omitempty"`
+	HTML    string `json:"html,omitempty"`
+	Text    string `json:"text,omitempty"`
+}
+
+type SMSContent struct {
+	Body string `json:"body"`
+}
+
+// PushContent is a push notification. Data is handed to the app as is.
+type PushContent struct {
+	Title string            `json:"title,omitempty"`
+	Body  string            `json:"body,omitempty"`
+	Data  map[string]string `json:"data,omitempty"`
+}
+
+// Validate checks the email against the subject length. It runs on
+// templates and on their rendered output alike.
+func (e EmailContent) Validate() error {
+	if length := utf8.RuneCountInString(e.Subject); length > MaxEmailSubjectLength {
+		return fmt.Errorf("%s (%d characters, at most %d)", ErrorEmailSubjectLong, length, MaxEmailSubjectLength)
+	}
+
+	if len(e.HTML) == 0 && len(e.Text) == 0 {
+		return errors.New(ErrorEmailEmpty)
+	}
+
+	return nil
+}
+
+// Validate checks the sms against the number of segments it is sent in.
+func (s SMSContent) Validate() error {
+	if len(s.Body) == 0 {
+		return errors.New(ErrorSMSEmpty)
+	}
+
+	if segments, encoding := SMSSegments(s.Body); segments > MaxSMSSegments {
+		return fmt.Errorf("%s (%d %s segments, at most %d)", ErrorSMSTooLong, segments, encoding, MaxSMSSegments)
+	}
+
+	return nil
+}
+
+// Validate checks the push against the payload size providers accept.
+func (p PushContent) Validate() error {
+	if len(p.Title) == 0 && len(p.Body) == 0 {
+		return errors.New(ErrorPushEmpty)
+	}
+
+	if size := p.PayloadSize(); size > MaxPushPayloadBytes {
+		return fmt.Errorf("%s (%d bytes, at most %d)", ErrorPushTooLarge, size, MaxPushPayloadBytes)
+	}
+
+	return nil
+}
+
+// PayloadSize is the size in bytes of the push serialised as JSON.
+func (p PushContent) PayloadSize() int {
+	payload, _ := json.Marshal(p)
+	return len(payload)
+}
+
+// fill sets the blocks of c that are not set yet from other.
+func (c *Channels) fill(other Channels) {
+	if c.Email == nil {
+		c.Email = other.Email
+	}
+	if c.SMS == nil {
+		c.SMS = other.SMS
+	}
+	if c.Push == nil {
+		c.Push = other.Push
+	}
+}
+
+// Validate checks every block that is set.
+func (c Channels) Validate() error {
+	if c.Email != nil {
+		if err := c.Email.Validate(); err != nil {
+			return fmt.Errorf("email: %v", err)
+		}
+	}
+	if c.SMS != nil {
+		if err := c.SMS.Validate(); err != nil {
+			return fmt.Errorf("sms: %v", err)
+		}
+	}
+	if c.Push != nil {
+		if err := c.Push.Validate(); err != nil {
+			return fmt.Errorf("push: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// SMSSegments returns the number of segments a body is sent in and its
+// encoding. Bodies within the GSM 03.38 alphabet take 160 characters in
+// one segment and 153 per segment when split; any other character makes
+// the whole body UCS-2, with 70 and 67.
+func SMSSegments(body string) (int, string) {
+	septets, gsm := 0, true
+	for _, r := range body {
+		if gsmExtended[r] {
+			septets += 2
+			continue
+		}
+		if !gsmBasic[r] {
+			gsm = false
+			break
+		}
+		septets++
+	}
+
+	if gsm {
+		return segments(septets, 160, 153), SMSEncodingGSM7
+	}
+
+	// UCS-2 counts in 16 bit units, so characters outside the BMP take two
+	units := 0
+	for _, r := range body {
+		if r > 0xFFFF {
+			units += 2
+		} else {
+			units++
+		}
+	}
+
+	return segments(units, 70, 67), SMSEncodingUCS2
+}
+
+func segments(length int, single int, multi int) int {
+	if length == 0 {
+		return 0
+	}
+	if length <= single {
+		return 1
+	}
+
+	return (length + multi - 1) / multi
+}
+
+var gsmBasic = runeSet("@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà")
+
+var gsmExtended = runeSet("^{}\\[~]|€\f")
+
+func runeSet(chars string) map[rune]bool {
+	set := make(map[rune]bool, len(chars))
+	for _, r := range chars {
+		set[r] = true
+	}
+
+	return set
+}
+
+// Content returns the block of the template for a locale and a channel,
+// building it from the localised title and body when it is left out. The
+// other blocks are not set, so only the channel that is delivered is
+// rendered and checked.
+func (t Template) Content(locale string, channel string) Channels {
+	variant, _ := t.Localise(locale)
+	written := *variant.Channels
+	channels := Channels{}
+
+	switch channel {
+	case ChannelEmail:
+		channels.Email = written.Email
+		if channels.Email == nil {
+			email := EmailContent{Subject: variant.Title}
+			if t.Format == FormatHTML {
+				email.HTML = variant.Body
+			} else {
+				email.Text = variant.Body
+			}
+			channels.Email = &email
+		} else if len(channels.Email.Subject) == 0 {
+			email := *channels.Email
+			email.Subject = variant.Title
+			channels.Email = &email
+		}
+	case ChannelSMS:
+		channels.SMS = written.SMS
+		if channels.SMS == nil {
+			channels.SMS = &SMSContent{Body: variant.Body}
+		}
+	case ChannelPush:
+		channels.Push = written.Push
+		if channels.Push == nil {
+			channels.Push = &PushContent{Title: variant.Title, Body: variant.Body}
+		}
+	}
+
+	return channels
+}
+
+// Derives tells whether the block of the channel is built from the body of
+// the template for a locale.
+func (t Template) Derives(locale string, channel string) bool {
+	variant, _ := t.Localise(locale)
+	switch channel {
+	case ChannelEmail:
+		return variant.Channels.Email == nil
+	case ChannelSMS:
+		return variant.Channels.SMS == nil
+	case ChannelPush:
+		return variant.Channels.Push == nil
+	}
+
+	return false
+}
@@ -0,0 +1,243 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	ConditionAll  = "all"
+	ConditionAny  = "any"
+	ConditionNot  = "not"
+	ConditionRule = "rule"
+)
+
+var (
+	ErrorInvalidCondition = "invalid condition. Each one must be exactly one of all, any, not or a rule"
+	ErrorEmptyCondition   = "all and any conditions must contain at least one condition"
+)
+
+// Condition is a node of the boolean expression a template is selected
+// with. It is a group of conditions that must all hold, a group of which
+// any must hold, the negation of a condition, or a leaf rule:
+//
+//	{"all": [
+//	  {"in": "plan", "op": "eq", "val": "pro"},
+//	  {"any": [
+//	    {"in": "country", "op": "eq", "val": "BR"},
+//	    {"in": "country", "op": "eq", "val": "PT"}
+//	  ]}
+//	]}
+type Condition struct {
+	All []Condition `json:"all,omitempty"`
+	Any []Condition `json:"any,omitempty"`
+	Not *Condition  `json:"not,omitempty"`
+	In  string      `json:"in,omitempty"`
+	Op  string      `json:"op,omitempty"`
+	Val string      `json:"val,omitempty"`
+}
+
+func (c Condition) kind() string {
+	kinds := []string{}
+	if c.All != nil {
+		kinds = append(kinds, ConditionAll)
+	}
+	if c.Any != nil {
+		kinds = append(kinds, ConditionAny)
+	}
+	if c.Not != nil {
+		kinds = append(kinds, ConditionNot)
+	}
+	if len(c.In) > 0 || len(c.Op) > 0 {
+		kinds = append(kinds, ConditionRule)
+	}
+
+	if len(kinds) != 1 {
+		return ""
+	}
+	return kinds[0]
+}
+
+func (c Condition) rule() Rule {
+	return Rule{In: c.In, Op: c.Op, Val: c.Val}
+}
+
+// Matches evaluates the condition for a row.
+func (c Condition) Matches(row map[string]interface{}, inputs map[string]interface{}) bool {
+	switch c.kind() {
+	case ConditionAll:
+		for _, child := range c.All {
+			if !child.Matches(row, inputs) {
+				return false
+			}
+		}
+		return true
+	case ConditionAny:
+		for _, child := range c.Any {
+			if child.Matches(row, inputs) {
+				return true
+			}
+		}
+		return false
+	case ConditionNot:
+		return !c.Not.Matches(row, inputs)
+	case ConditionRule:
+		return c.rule().Matches(row, inputs)
+	}
+
+	return false
+}
+
+// Validate checks the shape of the condition and its rules.
+func (c Condition) Validate() error {
+	switch c.kind() {
+	case ConditionAll, ConditionAny:
+		children := c.All
+		if c.Any != nil {
+			children = c.Any
+		}
+		if len(children) == 0 {
+			return errors.New(ErrorEmptyCondition)
+		}
+		for i, child := range children {
+			if err := child.Validate(); err != nil {
+				return fmt.Errorf("%s[%d]: %v", c.kind(), i, err)
+			}
+		}
+		return nil
+	case ConditionNot:
+		if err := c.Not.Validate(); err != nil {
+			return fmt.Errorf("not: %v", err)
+		}
+		return nil
+	case ConditionRule:
+		return c.rule().Validate()
+	}
+
+	return errors.New(ErrorInvalidCondition)
+}
+
+// leaves calls fn with every rule of the condition tree and its path.
+func (c Condition) leaves(path string, fn func(path string, r Rule)) {
+	switch c.kind() {
+	case ConditionAll:
+		for i, child := range c.All {
+			child.leaves(fmt.Sprintf("%s.all[%d]", path, i), fn)
+		}
+	case ConditionAny:
+		for i, child := range c.Any {
+			child.leaves(fmt.Sprintf("%s.any[%d]", path, i), fn)
+		}
+	case ConditionNot:
+		c.Not.leaves(path+".not", fn)
+	case ConditionRule:
+		fn(path, c.rule())
+	}
+}
+
+// Explanation tells how a condition or rule evaluated for a row. Every
+// branch is evaluated, so the ones that didn't decide the result show too.
+type Explanation struct {
+	Kind    string `json:"kind"`
+	Matched bool   `json:"matched"`
+	// NotApplicable marks rules that take no part in the selection, those
+	// of a default template.
+	NotApplicable bool          `json:"notApplicable,omitempty"`
+	Rule          *Rule         `json:"rule,omitempty"`
+	Value         interface{}   `json:"value,omitempty"`
+	Children      []Explanation `json:"children,omitempty"`
+}
+
+func (c Condition) Explain(row map[string]interface{}, inputs map[string]interface{}) Explanation {
+	e := Explanation{Kind: c.kind()}
+
+	switch e.Kind {
+	case ConditionAll:
+		e.Matched = true
+		for _, child := range c.All {
+			ce := child.Explain(row, inputs)
+			e.Matched = e.Matched && ce.Matched
+			e.Children = append(e.Children, ce)
+		}
+	case ConditionAny:
+		for _, child := range c.Any {
+			ce := child.Explain(row, inputs)
+			e.Matched = e.Matched || ce.Matched
+			e.Children = append(e.Children, ce)
+		}
+	case ConditionNot:
+		ce := c.Not.Explain(row, inputs)
+		e.Matched = !ce.Matched
+		e.Children = []Explanation{ce}
+	case ConditionRule:
+		return explainRule(c.rule(), row, inputs)
+	}
+
+	return e
+}
+
+func explainRule(r Rule, row map[string]interface{}, inputs map[string]interface{}) Explanation {
+	value, _ := lookupField(r.In, row, inputs)
+	return Explanation{Kind: ConditionRule, Matched: r.Matches(row, inputs), Rule: &r, Value: value}
+}
+
+// notApplicable marks an explanation and its children as not applicable.
+func (e Explanation) notApplicable() Explanation {
+	e.Matched = false
+	e.NotApplicable = true
+	for i := range e.Children {
+		e.Children[i] = e.Children[i].notApplicable()
+	}
+
+	return e
+}
+
+// TemplateExplanation tells how the rules of one template evaluated. A
+// default template is only selected when no other matches, so its rules
+// are reported as not applicable and it never matches itself.
+type TemplateExplanation struct {
+	Index   int           `json:"index"`
+	Default bool          `json:"default"`
+	Matched bool          `json:"matched"`
+	Rules   []Explanation `json:"rules,omitempty"`
+	When    *Explanation  `json:"when,omitempty"`
+}
+
+// SelectionExplanation tells which template a row selects and why.
+type SelectionExplanation struct {
+	Selected  *int                  `json:"selected"`
+	Fallback  bool                  `json:"fallback"`
+	Templates []TemplateExplanation `json:"templates"`
+}
+
+// ExplainSelection evaluates every template of the notification for a row.
+func (n Notification) ExplainSelection(row map[string]interface{}, inputs map[string]interface{}) SelectionExplanation {
+	result := SelectionExplanation{Templates: make([]TemplateExplanation, 0, len(n.Templates))}
+	for i, t := range n.Templates {
+		te := TemplateExplanation{Index: i, Default: t.Default, Matched: !t.Default}
+		for _, r := range t.Rules {
+			re := explainRule(r, row, inputs)
+			if t.Default {
+				re = re.notApplicable()
+			}
+			te.Matched = te.Matched && re.Matched
+			te.Rules = append(te.Rules, re)
+		}
+		if t.When != nil {
+			we := t.When.Explain(row, inputs)
+			if t.Default {
+				we = we.notApplicable()
+			}
+			te.Matched = te.Matched && we.Matched
+			te.When = &we
+		}
+		result.Templates = append(result.Templates, te)
+	}
+
+	if i, ok := n.SelectTemplate(row, inputs); ok {
+		result.Selected = &i
+		result.Fallback = n.Templates[i].Default
+	}
+
+	return result
+}
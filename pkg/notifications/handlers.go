@@ -1,6 +1,8 @@
 package notifications
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"hermes/pkg/common/crud"
 	"hermes/pkg/handlers"
@@ -14,6 +16,26 @@ type ErrorBody struct {
 	ErrorMsg *string `json:"error,omitempty"`
 }
 
+// ValidationErrorBody lists the fields a notification was rejected for.
+type ValidationErrorBody struct {
+	ErrorMsg *string      `json:"error,omitempty"`
+	Errors   []FieldError `json:"errors"`
+}
+
+// saveErrorResponse describes why a notification could not be saved.
+func saveErrorResponse(err error) (*events.APIGatewayProxyResponse, error) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return handlers.ApiResponse(http.StatusBadRequest, ValidationErrorBody{
+			aws.String(ErrorInvalidNotification), validationErr.Errors,
+		})
+	}
+
+	return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+		aws.String(err.Error()),
+	})
+}
+
 func GetNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
@@ -38,29 +60,25 @@ func GetNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository
 	return handlers.ApiResponse(http.StatusOK, result)
 }
 
-func NewNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (
+func NewNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository, datasetRepo crud.CrudRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	result, err := CreateNotification(req, repo)
+	result, err := CreateNotification(req, repo, datasetRepo)
 	if err != nil {
-		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return saveErrorResponse(err)
 	}
 	fmt.Println(result)
 	return handlers.ApiResponse(http.StatusCreated, result)
 }
 
-func SaveNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (
+func SaveNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository, datasetRepo crud.CrudRepository) (
 	*events.APIGatewayProxyResponse,
 	error,
 ) {
-	result, err := UpdateNotification(req, repo)
+	result, err := UpdateNotification(req, repo, datasetRepo)
 	if err != nil {
-		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
-			aws.String(err.Error()),
-		})
+		return saveErrorResponse(err)
 	}
 	return handlers.ApiResponse(http.StatusOK, result)
 }
@@ -77,3 +95,54 @@ func RemoveNotification(req events.APIGatewayProxyRequest, repo crud.CrudReposit
 	}
 	return handlers.ApiResponse(http.StatusOK, nil)
 }
+
+// ExplainRequest carries the sample row a template selection is explained
+// for.
+type ExplainRequest struct {
+	Row    map[string]interface{} `json:"row"`
+	Inputs map[string]interface{} `json:"inputs"`
+}
+
+func ExplainNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	r := ExplainRequest{}
+	if len(req.Body) > 0 {
+		if err := json.Unmarshal([]byte(req.Body), &r); err != nil {
+			return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+				aws.String(ErrorInvalidExplainData),
+			})
+		}
+	}
+
+	n, _ := FetchNotification(id, repo)
+	if n != nil && len(n.Name) == 0 {
+		return handlers.ApiResponse(http.StatusNotFound, ErrorBody{
+			aws.String(ErrorNotificationDoesNotExists),
+		})
+	}
+
+	inputs, err := n.Inputs.ResolveSupplied(r.Inputs)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+
+	return handlers.ApiResponse(http.StatusOK, n.ExplainSelection(r.Row, inputs))
+}
+
+func GetLocaleReport(req events.APIGatewayProxyRequest, repo crud.CrudRepository, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	n, _ := FetchNotification(id, repo)
+	if n != nil && len(n.Name) == 0 {
+		return handlers.ApiResponse(http.StatusNotFound, ErrorBody{
+			aws.String(ErrorNotificationDoesNotExists),
+		})
+	}
+
+	return handlers.ApiResponse(http.StatusOK, n.LocaleReport())
+}
@@ -0,0 +1,424 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const (
+	InputString  = "string"
+	InputInt     = "int"
+	InputDecimal = "decimal"
+	InputDate    = "date"
+	InputEnum    = "enum"
+	InputList    = "list"
+)
+
+var (
+	ErrorInvalidInputs       = "invalid input values"
+	ErrorInvalidInputType    = "invalid input type. Only string, int, decimal, date, enum and list are supported"
+	ErrorMissingInputName    = "input is missing its name"
+	ErrorDuplicateInput      = "input is declared more than once"
+	ErrorEnumWithoutValues   = "enum inputs need allowed values"
+	ErrorInvalidInputBound   = "invalid min or max for the input type"
+	ErrorInputBoundsReversed = "min is greater than max"
+	ErrorInvalidInputDefault = "invalid default value"
+	ErrorMissingInputValue   = "missing value"
+	ErrorInputValueType      = "value must be of type"
+	ErrorInputNotAllowed     = "value is not one of the allowed values"
+	ErrorInputPattern        = "value does not match the pattern"
+	ErrorInputBelowMin       = "value is below the minimum"
+	ErrorInputAboveMax       = "value is above the maximum"
+)
+
+// Input declares a value a notification is triggered with. Values are
+// bound to the query placeholders in the order inputs are declared, and
+// are available to templates as {{.Inputs.name}}.
+//
+// Min and Max bound the value of int and decimal inputs, the length of
+// string inputs, the number of items of list inputs and, as dates, date
+// inputs. Pattern applies to strings and list items, Allowed to strings,
+// enums and list items. Inputs declared by name only, the form inputs
+// used to have, are required and take any value as is. List inputs are
+// bound to sql queries as arrays, written as column = ANY($1).
+type Input struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type,omitempty"`
+	Required bool        `json:"required,omitempty"`
+	Default  interface{} `json:"default,omitempty"`
+	Pattern  string      `json:"pattern,omitempty"`
+	Min      interface{} `json:"min,omitempty"`
+	Max      interface{} `json:"max,omitempty"`
+	Allowed  []string    `json:"allowed,omitempty"`
+}
+
+// inputFields decodes an Input without its decoders.
+type inputFields Input
+
+func (in *Input) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*in = Input{Name: name, Required: true}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*inputFields)(in))
+}
+
+func (in *Input) UnmarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	if av.S != nil {
+		*in = Input{Name: *av.S, Required: true}
+		return nil
+	}
+
+	return dynamodbattribute.UnmarshalMap(av.M, (*inputFields)(in))
+}
+
+type Inputs []Input
+
+func (inputs Inputs) Names() []string {
+	names := make([]string, len(inputs))
+	for i, in := range inputs {
+		names[i] = in.Name
+	}
+
+	return names
+}
+
+// InputsError lists the input values that are missing or invalid, with
+// fields such as inputs.startDate.
+type InputsError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *InputsError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, f := range e.Errors {
+		messages[i] = f.Field + ": " + f.Message
+	}
+
+	return ErrorInvalidInputs + ": " + strings.Join(messages, "; ")
+}
+
+// Resolve checks values against the inputs and returns them coerced to
+// their types, with the defaults of those left out. Values of inputs that
+// are not declared are kept as they are.
+func (inputs Inputs) Resolve(values map[string]interface{}) (map[string]interface{}, error) {
+	return inputs.resolve(values, true)
+}
+
+// ResolveSupplied resolves like Resolve, but doesn't require values, for
+// previews rendered with some sample inputs only.
+func (inputs Inputs) ResolveSupplied(values map[string]interface{}) (map[string]interface{}, error) {
+	return inputs.resolve(values, false)
+}
+
+func (inputs Inputs) resolve(values map[string]interface{}, strict bool) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		resolved[name] = value
+	}
+
+	errs := &InputsError{}
+	for _, in := range inputs {
+		field := "inputs." + in.Name
+		value, ok := values[in.Name]
+		if !ok || value == nil {
+			if in.Default != nil {
+				value = in.Default
+			} else {
+				if strict && in.Required {
+					errs.Errors = append(errs.Errors, FieldError{field, ErrorMissingInputValue})
+				}
+				continue
+			}
+		}
+
+		coerced, err := in.Coerce(value)
+		if err != nil {
+			errs.Errors = append(errs.Errors, FieldError{field, err.Error()})
+			continue
+		}
+		resolved[in.Name] = coerced
+	}
+
+	if len(errs.Errors) > 0 {
+		return nil, errs
+	}
+	return resolved, nil
+}
+
+// Coerce converts a value to the input type and checks it against the
+// input constraints. Ints become int64, decimals float64 and lists
+// []string; dates stay the string they were written as.
+func (in Input) Coerce(value interface{}) (interface{}, error) {
+	switch in.Type {
+	case "":
+		return value, nil
+	case InputString, InputEnum:
+		s, ok := scalarString(value)
+		if !ok {
+			return nil, typeError(in.Type)
+		}
+		if err := in.checkString(s); err != nil {
+			return nil, err
+		}
+		if in.Type == InputString {
+			if err := in.checkBounds(float64(len([]rune(s)))); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case InputInt:
+		f, ok := inputNumber(value)
+		if !ok || f != math.Trunc(f) {
+			return nil, typeError(in.Type)
+		}
+		if err := in.checkBounds(f); err != nil {
+			return nil, err
+		}
+		return int64(f), nil
+	case InputDecimal:
+		f, ok := inputNumber(value)
+		if !ok {
+			return nil, typeError(in.Type)
+		}
+		if err := in.checkBounds(f); err != nil {
+			return nil, err
+		}
+		return f, nil
+	case InputDate:
+		s, ok := inputDate(value)
+		if !ok {
+			return nil, typeError(in.Type)
+		}
+		if err := in.checkDateBounds(s); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case InputList:
+		items, ok := inputList(value)
+		if !ok {
+			return nil, typeError(in.Type)
+		}
+		for _, item := range items {
+			if err := in.checkString(item); err != nil {
+				return nil, fmt.Errorf("%v: %s", err, item)
+			}
+		}
+		if err := in.checkBounds(float64(len(items))); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+
+	return nil, errors.New(ErrorInvalidInputType)
+}
+
+// Zero is a value of the input type, bound in place of the actual value
+// where only the shape of the query result matters. Inputs without a type
+// are bound as nulls.
+func (in Input) Zero() interface{} {
+	switch in.Type {
+	case InputString, InputEnum:
+		return ""
+	case InputInt:
+		return int64(0)
+	case InputDecimal:
+		return float64(0)
+	case InputDate:
+		return "1970-01-01"
+	case InputList:
+		return []string{}
+	}
+
+	return nil
+}
+
+// Validate checks the declaration of an input, its default included.
+func (in Input) Validate() error {
+	switch in.Type {
+	case "", InputString, InputInt, InputDecimal, InputDate, InputEnum, InputList:
+	default:
+		return errors.New(ErrorInvalidInputType)
+	}
+
+	if in.Type == InputEnum && len(in.Allowed) == 0 {
+		return errors.New(ErrorEnumWithoutValues)
+	}
+
+	if len(in.Pattern) > 0 {
+		if _, err := compileRegex(in.Pattern); err != nil {
+			return fmt.Errorf("%s: %v", ErrorInvalidRuleRegex, err)
+		}
+	}
+
+	if in.Min != nil || in.Max != nil {
+		if err := in.validateBounds(); err != nil {
+			return err
+		}
+	}
+
+	if in.Default != nil {
+		if _, err := in.Coerce(in.Default); err != nil {
+			return fmt.Errorf("%s: %v", ErrorInvalidInputDefault, err)
+		}
+	}
+
+	return nil
+}
+
+func (in Input) validateBounds() error {
+	if in.Type == "" || in.Type == InputEnum {
+		return errors.New(ErrorInvalidInputBound)
+	}
+
+	if in.Type == InputDate {
+		min, minOk := boundDate(in.Min)
+		max, maxOk := boundDate(in.Max)
+		if (in.Min != nil && !minOk) || (in.Max != nil && !maxOk) {
+			return errors.New(ErrorInvalidInputBound)
+		}
+		if minOk && maxOk && min.After(max) {
+			return errors.New(ErrorInputBoundsReversed)
+		}
+		return nil
+	}
+
+	min, minOk := inputNumber(in.Min)
+	max, maxOk := inputNumber(in.Max)
+	if (in.Min != nil && !minOk) || (in.Max != nil && !maxOk) {
+		return errors.New(ErrorInvalidInputBound)
+	}
+	if minOk && maxOk && min > max {
+		return errors.New(ErrorInputBoundsReversed)
+	}
+
+	return nil
+}
+
+func (in Input) checkString(s string) error {
+	if len(in.Pattern) > 0 {
+		re, err := compileRegex(in.Pattern)
+		if err != nil || !re.MatchString(s) {
+			return fmt.Errorf("%s %s", ErrorInputPattern, in.Pattern)
+		}
+	}
+
+	if len(in.Allowed) > 0 {
+		for _, allowed := range in.Allowed {
+			if s == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s (%s)", ErrorInputNotAllowed, strings.Join(in.Allowed, ", "))
+	}
+
+	return nil
+}
+
+func (in Input) checkBounds(f float64) error {
+	if min, ok := inputNumber(in.Min); ok && f < min {
+		return fmt.Errorf("%s %s", ErrorInputBelowMin, strconv.FormatFloat(min, 'f', -1, 64))
+	}
+	if max, ok := inputNumber(in.Max); ok && f > max {
+		return fmt.Errorf("%s %s", ErrorInputAboveMax, strconv.FormatFloat(max, 'f', -1, 64))
+	}
+
+	return nil
+}
+
+func (in Input) checkDateBounds(s string) error {
+	t, _ := parseDate(s)
+	if min, ok := boundDate(in.Min); ok && t.Before(min) {
+		return fmt.Errorf("%s %v", ErrorInputBelowMin, in.Min)
+	}
+	if max, ok := boundDate(in.Max); ok && t.After(max) {
+		return fmt.Errorf("%s %v", ErrorInputAboveMax, in.Max)
+	}
+
+	return nil
+}
+
+func typeError(inputType string) error {
+	return fmt.Errorf("%s %s", ErrorInputValueType, inputType)
+}
+
+func scalarString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool, float64, float32, int, int32, int64, json.Number:
+		return valueString(v), true
+	}
+
+	return "", false
+}
+
+// inputNumber reads numbers and numeric strings.
+func inputNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64, float32, int, int32, int64:
+		return toFloat(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+func inputDate(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339), true
+	case string:
+		s := strings.TrimSpace(v)
+		_, ok := parseDate(s)
+		return s, ok
+	}
+
+	return "", false
+}
+
+func boundDate(value interface{}) (time.Time, bool) {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return parseDate(strings.TrimSpace(s))
+}
+
+// inputList reads arrays of scalars and comma separated strings.
+func inputList(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case []string:
+		return v, true
+	case string:
+		return splitValues(v), true
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := scalarString(item)
+			if !ok {
+				return nil, false
+			}
+			items = append(items, s)
+		}
+		return items, true
+	}
+
+	return nil, false
+}
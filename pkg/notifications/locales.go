@@ -0,0 +1,198 @@
+package notifications
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrorInvalidLocale = "invalid locale. Use a language tag such as en, pt or pt-BR"
+)
+
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// Variant holds the title, body and channel blocks of a template in one
+// locale. Fields and blocks left out fall back along the locale chain.
+type Variant struct {
+	Title    string    `json:"title,omitempty"`
+	Body     string    `json:"body,omitempty"`
+	Channels *Channels `json:"channels,omitempty"`
+}
+
+// NormaliseLocale writes a locale as a language tag, e.g. "pt_br" becomes
+// "pt-BR".
+func NormaliseLocale(locale string) string {
+	parts := strings.Split(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"), "-")
+	for i, part := range parts {
+		switch {
+		case i == 0:
+			parts[i] = strings.ToLower(part)
+		case len(part) == 2:
+			parts[i] = strings.ToUpper(part)
+		case len(part) == 4:
+			parts[i] = strings.ToUpper(part[:1]) + strings.ToLower(part[1:])
+		default:
+			parts[i] = strings.ToLower(part)
+		}
+	}
+
+	return strings.Join(parts, "-")
+}
+
+// LocaleChain returns the locales tried for a locale, most specific first:
+// "pt-BR" gives pt-BR, pt. The template title and body come after them.
+func LocaleChain(locale string) []string {
+	locale = NormaliseLocale(locale)
+	if len(locale) == 0 {
+		return nil
+	}
+
+	parts := strings.Split(locale, "-")
+	chain := make([]string, 0, len(parts))
+	for i := len(parts); i > 0; i-- {
+		chain = append(chain, strings.Join(parts[:i], "-"))
+	}
+
+	return chain
+}
+
+// Localise returns the title, body and channel blocks of the template for
+// a locale and the most specific locale a variant was found for, empty when
+// only the template defaults apply. Channels is never nil, but only holds
+// the blocks that were written for the template. Once a variant sets the
+// title or body, the template blocks are left out: they are written in the
+// default language, so those channels are built from the variant instead.
+func (t Template) Localise(locale string) (Variant, string) {
+	variants := t.variants()
+	result := Variant{Channels: &Channels{}}
+	resolved := ""
+	localised := false
+
+	for _, l := range LocaleChain(locale) {
+		v, ok := variants[l]
+		if !ok {
+			continue
+		}
+		if len(resolved) == 0 {
+			resolved = l
+		}
+		if len(v.Title) > 0 || len(v.Body) > 0 {
+			localised = true
+		}
+		if len(result.Title) == 0 {
+			result.Title = v.Title
+		}
+		if len(result.Body) == 0 {
+			result.Body = v.Body
+		}
+		if v.Channels != nil {
+			result.Channels.fill(*v.Channels)
+		}
+	}
+
+	if len(result.Title) == 0 {
+		result.Title = t.Title
+	}
+	if len(result.Body) == 0 {
+		result.Body = t.Body
+	}
+	if t.Channels != nil && !localised {
+		result.Channels.fill(*t.Channels)
+	}
+
+	return result, resolved
+}
+
+// variants indexes the locales of the template by their normalised tag.
+func (t Template) variants() map[string]Variant {
+	variants := make(map[string]Variant, len(t.Locales))
+	for l, v := range t.Locales {
+		variants[NormaliseLocale(l)] = v
+	}
+
+	return variants
+}
+
+// TemplateLocales tells which locales a template covers. A locale served
+// through a less specific variant, e.g. pt-BR through pt, is a fallback; a
+// locale no variant serves is missing and is sent with the defaults.
+type TemplateLocales struct {
+	Index     int               `json:"index"`
+	Missing   []string          `json:"missing"`
+	Fallbacks map[string]string `json:"fallbacks,omitempty"`
+}
+
+type LocaleReport struct {
+	NotificationId string            `json:"notificationId"`
+	Locales        []string          `json:"locales"`
+	Missing        []string          `json:"missing"`
+	Templates      []TemplateLocales `json:"templates"`
+}
+
+// LocaleReport checks every template against the locales the notification
+// is sent in. Those are the declared Locales or, when there are none, every
+// locale some template has a variant for. Locales the defaults are written
+// in, as told by DefaultLocale, are never missing.
+func (n Notification) LocaleReport() LocaleReport {
+	expected := map[string]bool{}
+	for _, l := range n.Locales {
+		expected[NormaliseLocale(l)] = true
+	}
+	if len(expected) == 0 {
+		for _, t := range n.Templates {
+			for l := range t.variants() {
+				expected[l] = true
+			}
+		}
+	}
+
+	locales := make([]string, 0, len(expected))
+	for l := range expected {
+		locales = append(locales, l)
+	}
+	sort.Strings(locales)
+
+	covered := map[string]bool{}
+	if len(n.DefaultLocale) > 0 {
+		for _, l := range locales {
+			for _, c := range LocaleChain(l) {
+				if c == NormaliseLocale(n.DefaultLocale) {
+					covered[l] = true
+				}
+			}
+		}
+	}
+
+	report := LocaleReport{NotificationId: n.Id, Locales: locales, Missing: []string{}, Templates: []TemplateLocales{}}
+	missing := map[string]bool{}
+	for i, t := range n.Templates {
+		tl := TemplateLocales{Index: i, Missing: []string{}}
+		for _, l := range locales {
+			_, resolved := t.Localise(l)
+			switch resolved {
+			case l:
+			case "":
+				if covered[l] {
+					continue
+				}
+				tl.Missing = append(tl.Missing, l)
+				missing[l] = true
+			default:
+				if tl.Fallbacks == nil {
+					tl.Fallbacks = map[string]string{}
+				}
+				tl.Fallbacks[l] = resolved
+			}
+		}
+		report.Templates = append(report.Templates, tl)
+	}
+
+	for _, l := range locales {
+		if missing[l] {
+			report.Missing = append(report.Missing, l)
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,63 @@
+package notifications
+
+import "testing"
+
+func localisedTemplate() Template {
+	return Template{
+		Title: "Your order shipped",
+		Body:  "Order {{.Row.id}} is on its way",
+		Channels: &Channels{
+			Email: &EmailContent{Subject: "Shipped", Text: "Order {{.Row.id}} left our warehouse"},
+			SMS:   &SMSContent{Body: "Order {{.Row.id}} shipped"},
+		},
+		Locales: map[string]Variant{
+			"pt": {Title: "Pedido enviado", Body: "O pedido {{.Row.id}} está a caminho"},
+			"pt-BR": {Channels: &Channels{
+				Push: &PushContent{Title: "Pedido enviado", Body: "Seu pedido {{.Row.id}} saiu"},
+			}},
+		},
+	}
+}
+
+func TestContentBuildsChannelsFromTheLocaleVariant(t *testing.T) {
+	template := localisedTemplate()
+
+	sms := template.Content("pt-BR", ChannelSMS).SMS
+	if sms == nil || sms.Body != "O pedido {{.Row.id}} está a caminho" {
+		t.Fatalf("expected the sms to be built from the pt body, got %+v", sms)
+	}
+	if !template.Derives("pt-BR", ChannelSMS) {
+		t.Fatal("expected the pt-BR sms to be derived from the body")
+	}
+
+	email := template.Content("pt-BR", ChannelEmail).Email
+	if email == nil || email.Subject != "Pedido enviado" || email.Text != "O pedido {{.Row.id}} está a caminho" {
+		t.Fatalf("expected the email to be built from the pt variant, got %+v", email)
+	}
+
+	push := template.Content("pt-BR", ChannelPush).Push
+	if push == nil || push.Body != "Seu pedido {{.Row.id}} saiu" {
+		t.Fatalf("expected the pt-BR push block, got %+v", push)
+	}
+}
+
+func TestContentKeepsTemplateBlocksWithoutLocaleText(t *testing.T) {
+	template := localisedTemplate()
+
+	for _, locale := range []string{"", "en"} {
+		sms := template.Content(locale, ChannelSMS).SMS
+		if sms == nil || sms.Body != "Order {{.Row.id}} shipped" {
+			t.Fatalf("expected the template sms block for %q, got %+v", locale, sms)
+		}
+		if template.Derives(locale, ChannelSMS) {
+			t.Fatalf("expected the %q sms to use its written block", locale)
+		}
+	}
+
+	// a variant with blocks only still falls back to the template blocks
+	template.Locales = map[string]Variant{"pt-BR": template.Locales["pt-BR"]}
+	sms := template.Content("pt-BR", ChannelSMS).SMS
+	if sms == nil || sms.Body != "Order {{.Row.id}} shipped" {
+		t.Fatalf("expected the template sms block, got %+v", sms)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"hermes/pkg/common/crud"
 	BaseErrors "hermes/pkg/common/errors"
+	"hermes/pkg/datasets"
 
 	"github.com/aws/aws-lambda-go/events"
 )
@@ -14,6 +15,13 @@ var (
 	ErrorInvalidNotificationData   = "invalid  notification data"
 	ErrorNotificationAlreadyExists = "Notification already exists"
 	ErrorNotificationDoesNotExists = "Notification does not exist"
+	ErrorInvalidTemplateFormat     = "invalid template format. Only text and html are supported"
+	ErrorInvalidExplainData        = "invalid explain request"
+)
+
+const (
+	FormatText = "text"
+	FormatHTML = "html"
 )
 
 type Rule struct {
@@ -24,12 +32,28 @@ type Rule struct {
 
 type Template struct {
 	Rules []Rule `json:"rules"`
-	Title string `json:"title"`
-	Body  string `json:"body"`
+	// When is a condition tree the row must also satisfy, for selections
+	// the flat rules, which must all hold, can't express.
+	When  *Condition `json:"when,omitempty"`
+	Title string     `json:"title"`
+	Body  string     `json:"body"`
+	// Format tells how Body is rendered: text (the default) or html.
+	Format string `json:"format,omitempty"`
+	// Default makes the template apply to rows no other template matches.
+	Default bool `json:"default,omitempty"`
+	// Locales holds the title and body in other locales, keyed by language
+	// tag. Title and Body are used when no variant fits the recipient.
+	Locales map[string]Variant `json:"locales,omitempty"`
+	// Channels holds content written for a channel. Title and Body remain
+	// the content of channels without a block of their own. The blocks are
+	// not used for locales whose variant has its own title or body.
+	Channels *Channels `json:"channels,omitempty"`
 }
 type Query struct {
 	DataSetId string `json:"datasetId"`
 	Query     string `json:"query"`
+	// Recipients overrides the recipient mapping of the dataset.
+	Recipients *datasets.RecipientMapping `json:"recipients,omitempty"`
 }
 
 type Notification struct {
@@ -37,8 +61,15 @@ type Notification struct {
 	Name      string     `json:"name"`
 	Templates []Template `json:"templates"`
 	Query     `json:"query"`
-	Inputs    []string `json:"inputs"`
+	Inputs    Inputs   `json:"inputs"`
 	Tags      []string `json:"tags"`
+	Risk      *Risk    `json:"risk,omitempty"`
+	// Locales lists the locales the notification is sent in, which every
+	// template is expected to have a variant for.
+	Locales []string `json:"locales,omitempty"`
+	// DefaultLocale is the locale the template titles and bodies are
+	// written in.
+	DefaultLocale string `json:"defaultLocale,omitempty"`
 }
 
 func FetchNotification(id string, repo crud.CrudRepository) (*Notification, error) {
@@ -57,12 +88,22 @@ func FetchNotifications(repo crud.CrudRepository) (*[]Notification, error) {
 	return item, err
 }
 
-func CreateNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (*Notification, error) {
+// RecipientMapping returns the mapping of the notification query merged
+// over the one of its dataset.
+func (n Notification) RecipientMapping(d *datasets.DataSet) *datasets.RecipientMapping {
+	return d.Recipients.Merge(n.Query.Recipients)
+}
+
+func CreateNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository, datasetRepo crud.CrudRepository) (*Notification, error) {
 	var n Notification
 	if err := json.Unmarshal([]byte(req.Body), &n); err != nil {
 		return nil, errors.New(ErrorInvalidNotificationData)
 	}
 
+	if err := ValidateNotification(&n, datasetRepo); err != nil {
+		return nil, err
+	}
+
 	_, err := repo.Create(n)
 
 	if err != nil {
@@ -71,7 +112,7 @@ func CreateNotification(req events.APIGatewayProxyRequest, repo crud.CrudReposit
 	return &n, nil
 }
 
-func UpdateNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository) (
+func UpdateNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository, datasetRepo crud.CrudRepository) (
 	*Notification,
 	error,
 ) {
@@ -86,6 +127,13 @@ func UpdateNotification(req events.APIGatewayProxyRequest, repo crud.CrudReposit
 		return nil, errors.New(ErrorNotificationAlreadyExists)
 	}
 
+	if err := ValidateNotification(&n, datasetRepo); err != nil {
+		return nil, err
+	}
+
+	// risk is only written by the dataset health monitor
+	n.Risk = currentNotification.Risk
+
 	// Save dataset
 	_, err := repo.Update(n.Id, n)
 
@@ -0,0 +1,48 @@
+package notifications
+
+import (
+	"hermes/pkg/common/crud"
+	"hermes/pkg/common/redact"
+	"time"
+)
+
+// Risk flags a notification whose dataset keeps failing its health checks.
+type Risk struct {
+	DatasetId string `json:"datasetId"`
+	Reason    string `json:"reason"`
+	Since     string `json:"since"`
+}
+
+// SyncDatasetRisk marks the notifications using one of the datasets in
+// risks as at risk, and clears the flag from every other notification.
+// risks maps a dataset id to the reason it is considered unhealthy.
+func SyncDatasetRisk(repo crud.CrudRepository, risks map[string]string) error {
+	all, err := FetchNotifications(repo)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, n := range *all {
+		reason, atRisk := risks[n.Query.DataSetId]
+
+		// only risk is written, so edits made meanwhile are kept
+		var err error
+		switch {
+		case atRisk && (n.Risk == nil || n.Risk.Reason != reason):
+			since := now
+			if n.Risk != nil {
+				since = n.Risk.Since
+			}
+			err = repo.UpdateAttribute(n.Id, "risk", &Risk{DatasetId: n.Query.DataSetId, Reason: reason, Since: since})
+		case !atRisk && n.Risk != nil:
+			err = repo.UpdateAttribute(n.Id, "risk", nil)
+		}
+
+		if err != nil {
+			redact.Println(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,326 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OpEq       = "eq"
+	OpNeq      = "neq"
+	OpGt       = "gt"
+	OpGte      = "gte"
+	OpLt       = "lt"
+	OpLte      = "lte"
+	OpContains = "contains"
+	OpIn       = "in"
+	OpRegex    = "regex"
+	OpExists   = "exists"
+	OpBetween  = "between"
+)
+
+var (
+	ErrorInvalidRuleOp      = "invalid rule operator. Use eq, neq, gt, gte, lt, lte, contains, in, regex, exists or between"
+	ErrorMissingRuleField   = "rule is missing the field it applies to"
+	ErrorInvalidRuleRegex   = "rule has an invalid regular expression"
+	ErrorInvalidRuleBetween = "between rules take two values separated by a comma"
+	ErrorInvalidRuleList    = "in rules take one or more values separated by commas"
+	ErrorManyDefaults       = "only one template can be the default"
+)
+
+// inputPrefix makes a rule apply to an input of the notification instead
+// of a column of the row, e.g. "inputs.country".
+const inputPrefix = "inputs."
+
+// dateLayouts are the layouts strings are tried against when a rule
+// compares dates.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+var regexps sync.Map
+
+// Matches tells whether the rule holds for a row. Values are compared as
+// booleans, numbers or dates when both sides can be read as such, and as
+// strings otherwise. Rules on missing or null fields only hold for exists,
+// which holds when the field has a value; Val "false" negates it.
+func (r Rule) Matches(row map[string]interface{}, inputs map[string]interface{}) bool {
+	value, ok := lookupField(r.In, row, inputs)
+	present := ok && value != nil
+
+	if r.Op == OpExists {
+		return present != strings.EqualFold(strings.TrimSpace(r.Val), "false")
+	}
+	if !present {
+		return false
+	}
+
+	switch r.Op {
+	case OpEq:
+		c, ok := compare(value, r.Val)
+		return ok && c == 0
+	case OpNeq:
+		c, ok := compare(value, r.Val)
+		return !ok || c != 0
+	case OpGt:
+		c, ok := compare(value, r.Val)
+		return ok && c > 0
+	case OpGte:
+		c, ok := compare(value, r.Val)
+		return ok && c >= 0
+	case OpLt:
+		c, ok := compare(value, r.Val)
+		return ok && c < 0
+	case OpLte:
+		c, ok := compare(value, r.Val)
+		return ok && c <= 0
+	case OpContains:
+		if items, ok := value.([]interface{}); ok {
+			for _, item := range items {
+				if c, ok := compare(item, r.Val); ok && c == 0 {
+					return true
+				}
+			}
+			return false
+		}
+		return strings.Contains(valueString(value), r.Val)
+	case OpIn:
+		for _, candidate := range splitValues(r.Val) {
+			if c, ok := compare(value, candidate); ok && c == 0 {
+				return true
+			}
+		}
+		return false
+	case OpRegex:
+		re, err := compileRegex(r.Val)
+		return err == nil && re.MatchString(valueString(value))
+	case OpBetween:
+		bounds := splitValues(r.Val)
+		if len(bounds) != 2 {
+			return false
+		}
+		low, ok := compare(value, bounds[0])
+		if !ok {
+			return false
+		}
+		high, ok := compare(value, bounds[1])
+		return ok && low >= 0 && high <= 0
+	}
+
+	return false
+}
+
+// Validate checks the rule without evaluating it.
+func (r Rule) Validate() error {
+	if len(strings.TrimSpace(r.In)) == 0 {
+		return errors.New(ErrorMissingRuleField)
+	}
+
+	switch r.Op {
+	case OpEq, OpNeq, OpGt, OpGte, OpLt, OpLte, OpContains, OpExists:
+	case OpIn:
+		if len(splitValues(r.Val)) == 0 {
+			return errors.New(ErrorInvalidRuleList)
+		}
+	case OpBetween:
+		if len(splitValues(r.Val)) != 2 {
+			return errors.New(ErrorInvalidRuleBetween)
+		}
+	case OpRegex:
+		if _, err := compileRegex(r.Val); err != nil {
+			return fmt.Errorf("%s: %v", ErrorInvalidRuleRegex, err)
+		}
+	default:
+		return errors.New(ErrorInvalidRuleOp)
+	}
+
+	return nil
+}
+
+// Matches tells whether every rule of the template, and its condition
+// tree, hold for a row. A template without rules matches every row.
+func (t Template) Matches(row map[string]interface{}, inputs map[string]interface{}) bool {
+	for _, r := range t.Rules {
+		if !r.Matches(row, inputs) {
+			return false
+		}
+	}
+
+	return t.When == nil || t.When.Matches(row, inputs)
+}
+
+// SelectTemplate returns the index of the template that applies to a row:
+// the first one, in order, whose rules all hold, or else the default
+// template. It returns false when no template applies.
+func (n Notification) SelectTemplate(row map[string]interface{}, inputs map[string]interface{}) (int, bool) {
+	fallback := -1
+	for i, t := range n.Templates {
+		if t.Default {
+			fallback = i
+			continue
+		}
+		if t.Matches(row, inputs) {
+			return i, true
+		}
+	}
+
+	return fallback, fallback >= 0
+}
+
+func lookupField(in string, row map[string]interface{}, inputs map[string]interface{}) (interface{}, bool) {
+	in = strings.TrimSpace(in)
+	if strings.HasPrefix(in, inputPrefix) {
+		value, ok := inputs[strings.TrimPrefix(in, inputPrefix)]
+		return value, ok
+	}
+
+	value, ok := row[in]
+	return value, ok
+}
+
+// compare coerces the value and the rule operand to a common type and
+// returns -1, 0 or 1. It returns false when they can't be compared.
+func compare(value interface{}, operand string) (int, bool) {
+	operand = strings.TrimSpace(operand)
+
+	switch v := value.(type) {
+	case bool:
+		b, err := strconv.ParseBool(operand)
+		if err != nil {
+			return 0, false
+		}
+		return compareBools(v, b), true
+	case time.Time:
+		t, ok := parseDate(operand)
+		if !ok {
+			return 0, false
+		}
+		return compareTimes(v, t), true
+	case float64, float32, int, int32, int64:
+		f, err := strconv.ParseFloat(operand, 64)
+		if err != nil {
+			return 0, false
+		}
+		return compareFloats(toFloat(v), f), true
+	}
+
+	s := strings.TrimSpace(valueString(value))
+	if a, err := strconv.ParseFloat(s, 64); err == nil {
+		if b, err := strconv.ParseFloat(operand, 64); err == nil {
+			return compareFloats(a, b), true
+		}
+	}
+	if a, ok := parseDate(s); ok {
+		if b, ok := parseDate(operand); ok {
+			return compareTimes(a, b), true
+		}
+	}
+	if a, err := strconv.ParseBool(s); err == nil {
+		if b, err := strconv.ParseBool(operand); err == nil {
+			return compareBools(a, b), true
+		}
+	}
+
+	return strings.Compare(s, operand), true
+}
+
+func compareFloats(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareTimes(a time.Time, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareBools(a bool, b bool) int {
+	if a == b {
+		return 0
+	}
+	if !a {
+		return -1
+	}
+	return 1
+}
+
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
+
+func parseDate(s string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
+
+func splitValues(val string) []string {
+	values := []string{}
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexps.Store(expr, re)
+
+	return re, nil
+}
@@ -0,0 +1,293 @@
+package notifications
+
+import (
+	"fmt"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/datasets"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrorInvalidNotification = "invalid notification"
+	ErrorUnknownColumn       = "the query does not return the column"
+	ErrorUnknownInput        = "the notification does not declare the input"
+)
+
+// FieldError is a problem with one field of a notification. Field is a
+// path such as templates[0].rules[1].in.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError holds every problem found with a notification.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, f := range e.Errors {
+		messages[i] = f.Field + ": " + f.Message
+	}
+
+	return ErrorInvalidNotification + ": " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field string, message string) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: message})
+}
+
+func (e *ValidationError) addErr(field string, err error) {
+	if err != nil {
+		e.add(field, err.Error())
+	}
+}
+
+// ValidateNotification checks a notification before it is saved. Every
+// template is parsed, and the columns its variables and rules refer to are
+// checked against the columns the query returns, when the dataset can tell
+// them, as are the inputs they refer to. It returns a *ValidationError
+// listing every problem found.
+func ValidateNotification(n *Notification, datasetRepo crud.CrudRepository) error {
+	errs := &ValidationError{}
+
+	for i, l := range n.Locales {
+		if !validLocale(l) {
+			errs.add(fmt.Sprintf("locales[%d]", i), ErrorInvalidLocale)
+		}
+	}
+	if len(n.DefaultLocale) > 0 && !validLocale(n.DefaultLocale) {
+		errs.add("defaultLocale", ErrorInvalidLocale)
+	}
+
+	inputs := make(map[string]bool, len(n.Inputs))
+	for i, in := range n.Inputs {
+		field := fmt.Sprintf("inputs[%d]", i)
+		if len(strings.TrimSpace(in.Name)) == 0 {
+			errs.add(field+".name", ErrorMissingInputName)
+			continue
+		}
+		if inputs[in.Name] {
+			errs.add(field+".name", fmt.Sprintf("%s: %s", ErrorDuplicateInput, in.Name))
+		}
+		inputs[in.Name] = true
+		errs.addErr(field, in.Validate())
+	}
+
+	columns := validateQuery(n, datasetRepo, errs)
+
+	defaults := 0
+	for i, t := range n.Templates {
+		field := fmt.Sprintf("templates[%d]", i)
+		if t.Default {
+			defaults++
+		}
+
+		if len(t.Format) > 0 && t.Format != FormatText && t.Format != FormatHTML {
+			errs.add(field+".format", ErrorInvalidTemplateFormat)
+		}
+
+		for j, r := range t.Rules {
+			ruleField := fmt.Sprintf("%s.rules[%d]", field, j)
+			if err := r.Validate(); err != nil {
+				errs.addErr(ruleField, err)
+				continue
+			}
+			checkRuleField(ruleField+".in", r.In, columns, inputs, errs)
+		}
+
+		if t.When != nil {
+			if err := t.When.Validate(); err != nil {
+				errs.addErr(field+".when", err)
+			} else {
+				t.When.leaves(field+".when", func(path string, r Rule) {
+					checkRuleField(path+".in", r.In, columns, inputs, errs)
+				})
+			}
+		}
+
+		validateContent(field, Variant{Title: t.Title, Body: t.Body, Channels: t.Channels}, columns, inputs, errs)
+
+		locales := make([]string, 0, len(t.Locales))
+		for l := range t.Locales {
+			locales = append(locales, l)
+		}
+		sort.Strings(locales)
+		for _, l := range locales {
+			localeField := fmt.Sprintf("%s.locales.%s", field, l)
+			if !validLocale(l) {
+				errs.add(localeField, ErrorInvalidLocale)
+			}
+			validateContent(localeField, t.Locales[l], columns, inputs, errs)
+		}
+	}
+
+	if defaults > 1 {
+		errs.add("templates", ErrorManyDefaults)
+	}
+
+	if len(errs.Errors) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateQuery checks the dataset and the recipient mapping of the query
+// and returns the columns it returns, or nil when they can't be told.
+func validateQuery(n *Notification, datasetRepo crud.CrudRepository, errs *ValidationError) map[string]bool {
+	if len(n.Query.DataSetId) == 0 {
+		errs.addErr("query.recipients", datasets.ValidateRecipientMapping(n.Query.Recipients))
+		return nil
+	}
+
+	d, _ := datasets.FetchDataset(n.Query.DataSetId, datasetRepo)
+	if d != nil && len(d.Name) == 0 {
+		errs.add("query.datasetId", datasets.ErrorDatasetDoesNotExists)
+		return nil
+	}
+
+	mapping := n.RecipientMapping(d)
+	if err := datasets.ValidateRecipientMapping(mapping); err != nil {
+		errs.addErr("query.recipients", err)
+		mapping = nil
+	}
+
+	if len(strings.TrimSpace(n.Query.Query)) == 0 {
+		return nil
+	}
+
+	// inputs are bound as zero values of their type, only the shape of the
+	// result matters
+	args := make([]interface{}, len(n.Inputs))
+	for i, in := range n.Inputs {
+		args[i] = in.Zero()
+	}
+	names, ok, err := datasets.QueryColumns(d, n.Query.Query, args)
+	if err != nil {
+		errs.addErr("query.query", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	columns := make(map[string]bool, len(names))
+	for _, name := range names {
+		columns[name] = true
+	}
+
+	if mapping != nil {
+		if err := mapping.CheckColumns(names); err != nil {
+			errs.addErr("query.recipients", err)
+		}
+	}
+
+	return columns
+}
+
+// templateSource is a template source and the field it is written in.
+type templateSource struct {
+	field  string
+	source string
+}
+
+// sources lists the title, body and channel sources of a variant.
+func (v Variant) sources(field string) []templateSource {
+	sources := []templateSource{{field + ".title", v.Title}, {field + ".body", v.Body}}
+	if v.Channels == nil {
+		return sources
+	}
+
+	if e := v.Channels.Email; e != nil {
+		sources = append(sources,
+			templateSource{field + ".channels.email.subject", e.Subject},
+			templateSource{field + ".channels.email.html", e.HTML},
+			templateSource{field + ".channels.email.text", e.Text},
+		)
+	}
+	if s := v.Channels.SMS; s != nil {
+		sources = append(sources, templateSource{field + ".channels.sms.body", s.Body})
+	}
+	if p := v.Channels.Push; p != nil {
+		sources = append(sources,
+			templateSource{field + ".channels.push.title", p.Title},
+			templateSource{field + ".channels.push.body", p.Body},
+		)
+		keys := make([]string, 0, len(p.Data))
+		for key := range p.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			sources = append(sources, templateSource{field + ".channels.push.data." + key, p.Data[key]})
+		}
+	}
+
+	return sources
+}
+
+// Sources returns every template source the template holds, in its
+// defaults and in its locale variants.
+func (t Template) Sources() []string {
+	sources := Variant{Title: t.Title, Body: t.Body, Channels: t.Channels}.sources("")
+	for _, v := range t.Locales {
+		sources = append(sources, v.sources("")...)
+	}
+
+	result := make([]string, 0, len(sources))
+	for _, s := range sources {
+		if len(s.source) > 0 {
+			result = append(result, s.source)
+		}
+	}
+
+	return result
+}
+
+// validateContent checks the channel blocks of a template or variant and
+// parses every source it holds.
+func validateContent(field string, v Variant, columns map[string]bool, inputs map[string]bool, errs *ValidationError) {
+	if v.Channels != nil {
+		if e := v.Channels.Email; e != nil {
+			errs.addErr(field+".channels.email", e.Validate())
+		}
+		if s := v.Channels.SMS; s != nil {
+			errs.addErr(field+".channels.sms", s.Validate())
+		}
+		if p := v.Channels.Push; p != nil {
+			errs.addErr(field+".channels.push", p.Validate())
+		}
+	}
+
+	for _, s := range v.sources(field) {
+		if len(s.source) == 0 {
+			continue
+		}
+		vars, err := parseVariables(s.source)
+		if err != nil {
+			errs.addErr(s.field, err)
+			continue
+		}
+		vars.check(s.field, columns, inputs, errs)
+	}
+}
+
+func checkRuleField(field string, in string, columns map[string]bool, inputs map[string]bool, errs *ValidationError) {
+	in = strings.TrimSpace(in)
+	if strings.HasPrefix(in, inputPrefix) {
+		if name := strings.TrimPrefix(in, inputPrefix); !inputs[name] {
+			errs.add(field, fmt.Sprintf("%s: %s", ErrorUnknownInput, name))
+		}
+		return
+	}
+
+	if columns != nil && !columns[in] {
+		errs.add(field, fmt.Sprintf("%s: %s", ErrorUnknownColumn, in))
+	}
+}
+
+func validLocale(locale string) bool {
+	return localePattern.MatchString(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
@@ -0,0 +1,181 @@
+package notifications
+
+import (
+	"fmt"
+	"hermes/pkg/rendering/funcs"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+var (
+	ErrorUnknownVariable = "unknown variable. Templates can use .Row, .Inputs and .Locale"
+	ErrorDottedColumn    = "column names with dots are written as index .Row"
+)
+
+// variables are what a template source refers to: columns of the row, as
+// .Row.name or index .Row "name", and inputs, as .Inputs.name. paths holds
+// the fields of columns that are read further, as .Row.address.city, which
+// may be meant for a flattened column named address.city.
+type variables struct {
+	columns []string
+	paths   []string
+	inputs  []string
+	unknown []string
+}
+
+func (v *variables) add(list *[]string, name string) {
+	for _, known := range *list {
+		if known == name {
+			return
+		}
+	}
+	*list = append(*list, name)
+}
+
+// parseVariables parses a template source with the template function
+// library and collects the variables it refers to.
+func parseVariables(source string) (*variables, error) {
+	tmpl, err := template.New("").Funcs(funcs.Map()).Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &variables{}
+	if tmpl.Tree != nil {
+		v.walk(tmpl.Tree.Root, true)
+	}
+
+	return v, nil
+}
+
+// walk visits the nodes of a parse tree. root tells whether dot is still
+// the template data, which it no longer is inside range and with.
+func (v *variables) walk(node parse.Node, root bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			v.walk(child, root)
+		}
+	case *parse.ActionNode:
+		v.walk(n.Pipe, root)
+	case *parse.TemplateNode:
+		v.walk(n.Pipe, root)
+	case *parse.IfNode:
+		v.walkBranch(&n.BranchNode, root, root)
+	case *parse.RangeNode:
+		v.walkBranch(&n.BranchNode, false, root)
+	case *parse.WithNode:
+		v.walkBranch(&n.BranchNode, false, root)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			v.walk(cmd, root)
+		}
+	case *parse.CommandNode:
+		v.walkIndex(n, root)
+		for _, arg := range n.Args {
+			v.walk(arg, root)
+		}
+	case *parse.ChainNode:
+		v.walk(n.Node, root)
+	case *parse.FieldNode:
+		if root {
+			v.reference(n.Ident)
+		}
+	case *parse.VariableNode:
+		// $ is the template data wherever it is used
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			v.reference(n.Ident[1:])
+		}
+	}
+}
+
+func (v *variables) walkBranch(n *parse.BranchNode, listRoot bool, root bool) {
+	v.walk(n.Pipe, root)
+	v.walk(n.List, listRoot)
+	v.walk(n.ElseList, root)
+}
+
+// walkIndex records the column of index .Row "name", the way to write
+// columns whose names aren't identifiers.
+func (v *variables) walkIndex(n *parse.CommandNode, root bool) {
+	if len(n.Args) < 3 || !root {
+		return
+	}
+
+	fn, ok := n.Args[0].(*parse.IdentifierNode)
+	if !ok || fn.Ident != "index" {
+		return
+	}
+
+	field, ok := n.Args[1].(*parse.FieldNode)
+	if !ok || len(field.Ident) != 1 {
+		return
+	}
+
+	key, ok := n.Args[2].(*parse.StringNode)
+	if !ok {
+		return
+	}
+
+	switch field.Ident[0] {
+	case "Row":
+		v.add(&v.columns, key.Text)
+	case "Inputs":
+		v.add(&v.inputs, key.Text)
+	}
+}
+
+func (v *variables) reference(ident []string) {
+	switch ident[0] {
+	case "Row":
+		if len(ident) > 2 {
+			v.add(&v.paths, strings.Join(ident[1:], "."))
+		} else if len(ident) > 1 {
+			v.add(&v.columns, ident[1])
+		}
+	case "Inputs":
+		if len(ident) > 1 {
+			v.add(&v.inputs, ident[1])
+		}
+	case "Locale":
+	default:
+		v.add(&v.unknown, "."+ident[0])
+	}
+}
+
+// check reports the variables that are not among the known columns, when
+// they are known, and inputs.
+func (v *variables) check(field string, columns map[string]bool, inputs map[string]bool, errs *ValidationError) {
+	for _, name := range v.unknown {
+		errs.add(field, fmt.Sprintf("%s: %s", ErrorUnknownVariable, name))
+	}
+	if columns != nil {
+		for _, name := range v.columns {
+			if !columns[name] {
+				errs.add(field, fmt.Sprintf("%s: %s", ErrorUnknownColumn, name))
+			}
+		}
+		for _, path := range v.paths {
+			column := strings.SplitN(path, ".", 2)[0]
+			switch {
+			case columns[column]:
+			case columns[path]:
+				errs.add(field, fmt.Sprintf("%s %q", ErrorDottedColumn, path))
+			default:
+				errs.add(field, fmt.Sprintf("%s: %s", ErrorUnknownColumn, column))
+			}
+		}
+	}
+	for _, name := range v.inputs {
+		if !inputs[name] {
+			errs.add(field, fmt.Sprintf("%s: %s", ErrorUnknownInput, name))
+		}
+	}
+}
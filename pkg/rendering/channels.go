@@ -0,0 +1,185 @@
+package rendering
+
+import (
+	"fmt"
+	"hermes/pkg/links"
+	"hermes/pkg/notifications"
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	htmlDropped = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>|<!--.*?-->`)
+	htmlLink    = regexp.MustCompile(`(?is)<a\b[^>]*?\bhref\s*=\s*(?:"([^"]*)"|'([^']*)')[^>]*>(.*?)</a\s*>`)
+	htmlBreak   = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6]|blockquote|table)\s*>`)
+	htmlTag     = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaces      = regexp.MustCompile(`[ \t\f\v\x{a0}]+`)
+)
+
+type RenderedSMS struct {
+	Body     string `json:"body"`
+	Segments int    `json:"segments"`
+	Encoding string `json:"encoding"`
+}
+
+type RenderedPush struct {
+	notifications.PushContent
+	Size int `json:"size"`
+}
+
+// renderChannels renders the blocks of content that are set and checks the
+// output of each against the limits of its channel, once its links are
+// tracked.
+func renderChannels(content notifications.Channels, rendered *Rendered, data Data, opts Options) error {
+	if content.Email != nil {
+		email, err := renderEmail(*content.Email, data, opts)
+		if err != nil {
+			return fmt.Errorf("email: %v", err)
+		}
+		if len(email.Subject) == 0 {
+			email.Subject = rendered.Title
+		}
+		if err := email.Validate(); err != nil {
+			return fmt.Errorf("email: %v", err)
+		}
+		rendered.Email = email
+	}
+
+	if content.SMS != nil {
+		body, err := RenderText("sms", content.SMS.Body, data, opts.Layouts)
+		if err == nil {
+			body, err = opts.Links.RewriteText(body, opts.Context)
+		}
+		if err != nil {
+			return fmt.Errorf("sms: %v", err)
+		}
+		rendered.SMS, err = checkSMS(body)
+		if err != nil {
+			return fmt.Errorf("sms: %v", err)
+		}
+	}
+
+	if content.Push != nil {
+		push, err := renderPush(*content.Push, data, opts)
+		if err != nil {
+			return fmt.Errorf("push: %v", err)
+		}
+		rendered.Push, err = checkPush(*push)
+		if err != nil {
+			return fmt.Errorf("push: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func checkSMS(body string) (*RenderedSMS, error) {
+	sms := notifications.SMSContent{Body: body}
+	if err := sms.Validate(); err != nil {
+		return nil, err
+	}
+
+	segments, encoding := notifications.SMSSegments(body)
+	return &RenderedSMS{Body: body, Segments: segments, Encoding: encoding}, nil
+}
+
+func checkPush(push notifications.PushContent) (*RenderedPush, error) {
+	if err := push.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &RenderedPush{PushContent: push, Size: push.PayloadSize()}, nil
+}
+
+func renderEmail(e notifications.EmailContent, data Data, opts Options) (*notifications.EmailContent, error) {
+	subject, err := RenderText("subject", e.Subject, data, opts.Layouts)
+	if err != nil {
+		return nil, err
+	}
+
+	html, err := RenderHTML("html", e.HTML, data, opts.Layouts)
+	if err != nil {
+		return nil, err
+	}
+	html, err = opts.Links.RewriteHTML(html, opts.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := RenderText("text", e.Text, data, opts.Layouts)
+	if err != nil {
+		return nil, err
+	}
+	text, err = opts.Links.RewriteText(text, opts.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notifications.EmailContent{Subject: links.StripNoTrack(subject), HTML: html, Text: text}, nil
+}
+
+func renderPush(p notifications.PushContent, data Data, opts Options) (*notifications.PushContent, error) {
+	title, err := RenderText("title", p.Title, data, opts.Layouts)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := RenderText("body", p.Body, data, opts.Layouts)
+	if err != nil {
+		return nil, err
+	}
+	body, err = opts.Links.RewriteText(body, opts.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]string
+	if len(p.Data) > 0 {
+		values = make(map[string]string, len(p.Data))
+		for key, source := range p.Data {
+			value, err := RenderText("data."+key, source, data, opts.Layouts)
+			if err == nil {
+				value, err = opts.Links.RewriteText(value, opts.Context)
+			}
+			if err != nil {
+				return nil, err
+			}
+			values[key] = value
+		}
+	}
+
+	return &notifications.PushContent{Title: links.StripNoTrack(title), Body: body, Data: values}, nil
+}
+
+// htmlText turns a rendered html body into text for the channels that
+// can't show markup. Links keep their target after their label, and
+// blocks and line breaks end lines.
+func htmlText(body string) string {
+	body = htmlDropped.ReplaceAllString(body, "")
+	body = htmlLink.ReplaceAllStringFunc(body, func(link string) string {
+		match := htmlLink.FindStringSubmatch(link)
+		href := strings.TrimSpace(match[1] + match[2])
+		label := strings.TrimSpace(htmlTag.ReplaceAllString(match[3], ""))
+		if len(href) == 0 || href == label {
+			return label
+		}
+		if len(label) == 0 {
+			return href
+		}
+		return label + " (" + href + ")"
+	})
+	body = htmlBreak.ReplaceAllString(body, "\n")
+	body = html.UnescapeString(htmlTag.ReplaceAllString(body, ""))
+
+	lines := []string{}
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(spaces.ReplaceAllString(line, " "))
+		if len(line) == 0 && (len(lines) == 0 || len(lines[len(lines)-1]) == 0) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,251 @@
+package funcs
+
+import (
+	"fmt"
+	"hermes/pkg/links"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// currencies holds the symbol and the number of minor units of the
+// currencies the currency function knows. Others are written with their
+// code and two decimals.
+var currencies = map[string]struct {
+	symbol   string
+	decimals int
+}{
+	"USD": {"$", 2},
+	"EUR": {"€", 2},
+	"GBP": {"£", 2},
+	"BRL": {"R$", 2},
+	"MXN": {"MX$", 2},
+	"ARS": {"AR$", 2},
+	"CAD": {"CA$", 2},
+	"AUD": {"A$", 2},
+	"JPY": {"¥", 0},
+	"INR": {"₹", 2},
+}
+
+// dateLayouts are the layouts date accepts for values that are strings.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// Map is the function library available to every template. Functions
+// take the value they work on last, so they can end a pipeline:
+//
+//	{{.Row.createdAt | date "02 Jan 2006"}}
+//	{{.Row.total | currency "EUR"}}
+//	{{pluralise .Row.items "item" "items"}}
+//	{{.Row.name | default "there" | upper}}
+//
+// extends writes nothing: a body starting with {{extends "id"}} is
+// rendered inside the content block of that layout. notrack marks a URL
+// to be left as written when links are tracked: {{.Row.url | notrack}}.
+func Map() map[string]interface{} {
+	return map[string]interface{}{
+		"date":      formatDate,
+		"number":    formatNumber,
+		"currency":  formatCurrency,
+		"pluralise": pluralise,
+		"pluralize": pluralise,
+		"default":   defaultValue,
+		"truncate":  truncate,
+		"upper":     func(value interface{}) string { return strings.ToUpper(toString(value)) },
+		"lower":     func(value interface{}) string { return strings.ToLower(toString(value)) },
+		"extends":   func(layout string) string { return "" },
+		"notrack":   func(value interface{}) string { return toString(value) + links.NoTrack },
+	}
+}
+
+// formatDate writes a time, a date string or a unix timestamp in seconds
+// using a Go layout.
+func formatDate(layout string, value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+
+	t, err := toTime(value)
+	if err != nil {
+		return "", err
+	}
+
+	return t.Format(layout), nil
+}
+
+// formatNumber writes a number with the given decimals and thousands
+// separated by commas.
+func formatNumber(decimals int, value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+
+	f, err := toFloat(value)
+	if err != nil {
+		return "", err
+	}
+
+	return groupThousands(f, decimals), nil
+}
+
+func formatCurrency(code string, value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+
+	f, err := toFloat(value)
+	if err != nil {
+		return "", err
+	}
+
+	code = strings.ToUpper(code)
+	c, ok := currencies[code]
+	if !ok {
+		return code + " " + groupThousands(f, 2), nil
+	}
+
+	if f < 0 {
+		return "-" + c.symbol + groupThousands(-f, c.decimals), nil
+	}
+	return c.symbol + groupThousands(f, c.decimals), nil
+}
+
+// pluralise picks singular when count is one and plural otherwise.
+func pluralise(count interface{}, singular string, plural string) (string, error) {
+	f, err := toFloat(count)
+	if err != nil {
+		return "", err
+	}
+
+	if f == 1 {
+		return singular, nil
+	}
+	return plural, nil
+}
+
+// defaultValue returns fallback when value is nil, empty or zero.
+func defaultValue(fallback interface{}, value interface{}) interface{} {
+	if value == nil {
+		return fallback
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if v.Len() == 0 {
+			return fallback
+		}
+	default:
+		if v.IsZero() {
+			return fallback
+		}
+	}
+
+	return value
+}
+
+// truncate cuts a value down to length characters, ending it with an
+// ellipsis when something was cut.
+func truncate(length int, value interface{}) string {
+	s := toString(value)
+	if length <= 0 || utf8.RuneCountInString(s) <= length {
+		return s
+	}
+
+	runes := []rune(s)
+	if length == 1 {
+		return "…"
+	}
+	return strings.TrimRightFunc(string(runes[:length-1]), func(r rune) bool { return r == ' ' }) + "…"
+}
+
+func groupThousands(f float64, decimals int) string {
+	if decimals < 0 {
+		decimals = 0
+	}
+
+	s := strconv.FormatFloat(math.Abs(f), 'f', decimals, 64)
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i:]
+	}
+
+	var b strings.Builder
+	if f < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	b.WriteString(fraction)
+
+	return b.String()
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return f, nil
+		}
+	case []byte:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(string(v)), 64); err == nil {
+			return f, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%v is not a number", value)
+}
+
+func toTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+				return t, nil
+			}
+		}
+	case float64, int, int64:
+		seconds, _ := toFloat(v)
+		return time.Unix(int64(seconds), 0).UTC(), nil
+	}
+
+	return time.Time{}, fmt.Errorf("%v is not a date", value)
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,27 @@
+package rendering
+
+import (
+	"hermes/pkg/common/crud"
+	"hermes/pkg/handlers"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type ErrorBody struct {
+	ErrorMsg *string `json:"error,omitempty"`
+}
+
+func RenderNotification(req events.APIGatewayProxyRequest, repo crud.CrudRepository, layoutRepo crud.CrudRepository, linkRepo crud.CrudRepository, id string) (
+	*events.APIGatewayProxyResponse,
+	error,
+) {
+	result, err := PreviewTemplates(req, repo, layoutRepo, linkRepo, id)
+	if err != nil {
+		return handlers.ApiResponse(http.StatusBadRequest, ErrorBody{
+			aws.String(err.Error()),
+		})
+	}
+	return handlers.ApiResponse(http.StatusOK, result)
+}
@@ -0,0 +1,92 @@
+package rendering
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hermes/pkg/common/crud"
+	"hermes/pkg/layouts"
+	"hermes/pkg/links"
+	"hermes/pkg/notifications"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var (
+	ErrorTemplateDoesNotExist = "template does not exist"
+)
+
+// RenderRequest carries the sample data a preview is rendered with.
+// Template is the index of the template to render; all of them are
+// rendered when it is left out.
+type RenderRequest struct {
+	Template *int                   `json:"template"`
+	Row      map[string]interface{} `json:"row"`
+	Inputs   map[string]interface{} `json:"inputs"`
+	Locale   string                 `json:"locale"`
+	// TrackLinks issues the tracked links the message would be sent with,
+	// for CampaignId when it is set.
+	TrackLinks bool   `json:"trackLinks"`
+	CampaignId string `json:"campaignId"`
+}
+
+type RenderedTemplate struct {
+	Index int `json:"index"`
+	// Selected tells whether the rules pick this template for the row.
+	Selected bool `json:"selected"`
+	Rendered
+}
+
+func PreviewTemplates(req events.APIGatewayProxyRequest, repo crud.CrudRepository, layoutRepo crud.CrudRepository, linkRepo crud.CrudRepository, id string) ([]RenderedTemplate, error) {
+	r := RenderRequest{}
+	if len(req.Body) > 0 {
+		if err := json.Unmarshal([]byte(req.Body), &r); err != nil {
+			return nil, errors.New(ErrorInvalidRenderData)
+		}
+	}
+
+	n, _ := notifications.FetchNotification(id, repo)
+	if n != nil && len(n.Name) == 0 {
+		return nil, errors.New(notifications.ErrorNotificationDoesNotExists)
+	}
+
+	data := Data{Row: r.Row, Locale: r.Locale}
+	if data.Row == nil {
+		data.Row = map[string]interface{}{}
+	}
+	inputs, err := n.Inputs.ResolveSupplied(r.Inputs)
+	if err != nil {
+		return nil, err
+	}
+	data.Inputs = inputs
+
+	indexes := []int{}
+	if r.Template != nil {
+		if *r.Template < 0 || *r.Template >= len(n.Templates) {
+			return nil, errors.New(ErrorTemplateDoesNotExist)
+		}
+		indexes = append(indexes, *r.Template)
+	} else {
+		for i := range n.Templates {
+			indexes = append(indexes, i)
+		}
+	}
+
+	selected, _ := n.SelectTemplate(data.Row, data.Inputs)
+	opts := Options{Layouts: layouts.NewLibrary(layoutRepo)}
+	if r.TrackLinks {
+		opts.Links = links.NewTrackerFromEnv(linkRepo)
+		opts.Context = links.Context{CampaignId: r.CampaignId, NotificationId: n.Id}
+	}
+
+	result := make([]RenderedTemplate, 0, len(indexes))
+	for _, i := range indexes {
+		rendered, err := Render(n.Templates[i], data, opts)
+		if err != nil {
+			return nil, fmt.Errorf("template %d: %v", i, err)
+		}
+		result = append(result, RenderedTemplate{Index: i, Selected: i == selected, Rendered: *rendered})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,235 @@
+package rendering
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hermes/pkg/layouts"
+	"hermes/pkg/links"
+	"hermes/pkg/notifications"
+	"hermes/pkg/rendering/funcs"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	texttemplate "text/template"
+)
+
+// noValue is what text/template writes for a value that is missing, such
+// as a column a sparse row leaves out.
+const noValue = "<no value>"
+
+var (
+	ErrorInvalidTemplate   = "invalid template"
+	ErrorCouldNotRender    = "could not render template"
+	ErrorInvalidRenderData = "invalid render request"
+	ErrorNoTemplateMatches = "no template applies to the row"
+)
+
+// Data is what templates are executed against: the row of the recipient
+// and the inputs the notification was sent with, written as {{.Row.name}}
+// and {{.Inputs.name}}. Locale picks the template variant and is available
+// as {{.Locale}}.
+type Data struct {
+	Row    map[string]interface{} `json:"row"`
+	Inputs map[string]interface{} `json:"inputs"`
+	Locale string                 `json:"locale"`
+}
+
+// Rendered is the output of a template for one recipient.
+type Rendered struct {
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	Format string `json:"format"`
+	// Locale is the variant the output was rendered from, empty for the
+	// template defaults.
+	Locale string                      `json:"locale,omitempty"`
+	Email  *notifications.EmailContent `json:"email,omitempty"`
+	SMS    *RenderedSMS                `json:"sms,omitempty"`
+	Push   *RenderedPush               `json:"push,omitempty"`
+}
+
+// Options holds what rendering uses besides the template and its data.
+type Options struct {
+	// Layouts loads the layouts and partials templates use. It may be nil
+	// when they use none.
+	Layouts *layouts.Library
+	// Links turns the links of the output into tracked short links, which
+	// carry Context. Links are left as written when it is nil.
+	Links   *links.Tracker
+	Context links.Context
+}
+
+// Render executes the title and the body of a template, in the variant for
+// the data locale, against data, along with the channel blocks written for
+// it. The title is always text; the body is escaped as HTML when the
+// template format is html. Links in the body and the channel blocks are
+// tracked when opts has a tracker.
+func Render(t notifications.Template, data Data, opts Options) (*Rendered, error) {
+	variant, locale := t.Localise(data.Locale)
+	return render(t, variant, locale, data, opts)
+}
+
+// RenderContent renders like Render, but only the block of channel, which
+// is built from the title and body of the template when it was not
+// written, as it is when the notification is sent. sms and push take text,
+// so an html body they are built from is turned into text once rendered.
+func RenderContent(t notifications.Template, channel string, data Data, opts Options) (*Rendered, error) {
+	variant, locale := t.Localise(data.Locale)
+	content := t.Content(data.Locale, channel)
+	fromHTML := t.Format == notifications.FormatHTML && channel != notifications.ChannelEmail && t.Derives(data.Locale, channel)
+	if fromHTML {
+		content = notifications.Channels{}
+	}
+	variant.Channels = &content
+
+	rendered, err := render(t, variant, locale, data, opts)
+	if err != nil || !fromHTML {
+		return rendered, err
+	}
+
+	body := htmlText(rendered.Body)
+	if channel == notifications.ChannelSMS {
+		rendered.SMS, err = checkSMS(body)
+		if err != nil {
+			return nil, fmt.Errorf("sms: %v", err)
+		}
+	} else {
+		rendered.Push, err = checkPush(notifications.PushContent{Title: rendered.Title, Body: body})
+		if err != nil {
+			return nil, fmt.Errorf("push: %v", err)
+		}
+	}
+
+	return rendered, nil
+}
+
+func render(t notifications.Template, variant notifications.Variant, locale string, data Data, opts Options) (*Rendered, error) {
+	title, err := RenderText("title", variant.Title, data, opts.Layouts)
+	if err != nil {
+		return nil, err
+	}
+	title = links.StripNoTrack(title)
+
+	format := t.Format
+	if len(format) == 0 {
+		format = notifications.FormatText
+	}
+
+	var body string
+	if format == notifications.FormatHTML {
+		body, err = RenderHTML("body", variant.Body, data, opts.Layouts)
+		if err == nil {
+			body, err = opts.Links.RewriteHTML(body, opts.Context)
+		}
+	} else {
+		body, err = RenderText("body", variant.Body, data, opts.Layouts)
+		if err == nil {
+			body, err = opts.Links.RewriteText(body, opts.Context)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := &Rendered{Title: title, Body: body, Format: format, Locale: locale}
+	if err := renderChannels(*variant.Channels, rendered, data, opts); err != nil {
+		return nil, err
+	}
+
+	return rendered, nil
+}
+
+// RenderRow renders the template the notification rules select for a row.
+func RenderRow(n *notifications.Notification, data Data, opts Options) (int, *Rendered, error) {
+	i, ok := n.SelectTemplate(data.Row, data.Inputs)
+	if !ok {
+		return -1, nil, errors.New(ErrorNoTemplateMatches)
+	}
+
+	rendered, err := Render(n.Templates[i], data, opts)
+	if err != nil {
+		return i, nil, err
+	}
+
+	return i, rendered, nil
+}
+
+// RenderText renders source with text/template, along with the layout it
+// extends and the partials it includes. lib may be nil when source uses
+// neither. A missing key is a nil value, so that default and if work on
+// columns a row leaves out, and it is written as an empty string.
+func RenderText(name string, source string, data interface{}, lib *layouts.Library) (string, error) {
+	out, err := execute(name, source, data, lib, func(name string) template {
+		return textTemplate{texttemplate.New(name).Funcs(funcs.Map()).Option("missingkey=zero")}
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ReplaceAll(out, noValue, ""), nil
+}
+
+// RenderHTML renders like RenderText with html/template, escaping the
+// values it writes for the context they are written in.
+func RenderHTML(name string, source string, data interface{}, lib *layouts.Library) (string, error) {
+	return execute(name, source, data, lib, func(name string) template {
+		return htmlTemplate{htmltemplate.New(name).Funcs(funcs.Map()).Option("missingkey=zero")}
+	})
+}
+
+// template is what rendering uses of a text/template or html/template.
+type template interface {
+	New(name string) template
+	Parse(source string) error
+	Execute(w io.Writer, data interface{}) error
+}
+
+type textTemplate struct{ t *texttemplate.Template }
+
+func (t textTemplate) New(name string) template { return textTemplate{t.t.New(name)} }
+
+func (t textTemplate) Parse(source string) error {
+	_, err := t.t.Parse(source)
+	return err
+}
+
+func (t textTemplate) Execute(w io.Writer, data interface{}) error { return t.t.Execute(w, data) }
+
+type htmlTemplate struct{ t *htmltemplate.Template }
+
+func (t htmlTemplate) New(name string) template { return htmlTemplate{t.t.New(name)} }
+
+func (t htmlTemplate) Parse(source string) error {
+	_, err := t.t.Parse(source)
+	return err
+}
+
+func (t htmlTemplate) Execute(w io.Writer, data interface{}) error { return t.t.Execute(w, data) }
+
+// execute composes source with its layout and partials, parses them into
+// the template newTemplate returns and executes it.
+func execute(name string, source string, data interface{}, lib *layouts.Library, newTemplate func(name string) template) (string, error) {
+	sources, err := lib.Compose(name, source)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", ErrorInvalidTemplate, err)
+	}
+
+	// the first source is the template itself, the others are associated
+	tmpl := newTemplate(name)
+	for i, s := range sources {
+		t := tmpl
+		if i > 0 {
+			t = tmpl.New(s.Name)
+		}
+		if err := t.Parse(s.Source); err != nil {
+			return "", fmt.Errorf("%s: %v", ErrorInvalidTemplate, err)
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return "", fmt.Errorf("%s: %v", ErrorCouldNotRender, err)
+	}
+
+	return buffer.String(), nil
+}